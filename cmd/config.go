@@ -0,0 +1,32 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"xm/configs"
+)
+
+func config(args []string) error {
+	if len(args) == 0 || args[0] != "check" {
+		return errors.New("usage: xm config check")
+	}
+
+	cfg, err := configs.Load(os.Args[1:], os.Environ())
+	if err != nil {
+		return err
+	}
+
+	err = configs.Validate(cfg)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range configs.Dump(cfg) {
+		fmt.Printf("%s=%s (%s)\n", s.Key, s.Value, s.Source)
+	}
+
+	fmt.Println("configuration OK")
+
+	return nil
+}
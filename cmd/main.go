@@ -1,29 +1,86 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"os"
 	"xm/configs"
-	"xm/gateways"
-	"xm/pkg/db"
-	"xm/pkg/handlers"
-	"xm/pkg/handlers/server"
-	"xm/pkg/logger"
-	"xm/pkg/repositories"
-	"xm/pkg/services"
 
 	"go.uber.org/fx"
 )
 
+const usage = `usage: xm [--config file] [--<setting> value] <command>
+
+commands:
+  serve                           run the HTTP server (default)
+  migrate up|down|status|to <n>   manage the database schema
+  user create                     create a user
+  user set-password               change a user's password
+  seed                            load demo data
+  config check                    validate the configuration
+  version                         print the version`
+
+var commands = map[string]func(args []string) error{
+	"serve":   serve,
+	"migrate": migrate,
+	"user":    user,
+	"seed":    seed,
+	"config":  config,
+	"version": printVersion,
+}
+
 func main() {
-	fx.New(
-		fx.Options(
-			configs.Module,
-			logger.Module,
-			db.Module,
-			repositories.Module,
-			services.Module,
-			handlers.Module,
-			server.Module,
-			gateways.Module,
-		),
-	).Run()
+	args := configs.StripFlags(os.Args[1:])
+
+	name := "serve"
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	err := cmd(args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// run starts an app built from opts, calls fn and stops the app again.
+// Commands use it to build only the modules they need.
+func run(fn func(ctx context.Context) error, opts ...fx.Option) (err error) {
+	app := fx.New(append(opts, fx.NopLogger, startTimeout())...)
+
+	ctx := context.Background()
+
+	err = app.Start(ctx)
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		stopErr := app.Stop(ctx)
+		if err == nil {
+			err = stopErr
+		}
+	}()
+
+	return fn(ctx)
+}
+
+// startTimeout leaves OnStart hooks enough time to spend the startup
+// retry budget on both postgres and nats before fx gives up on them.
+func startTimeout() fx.Option {
+	timeout := fx.DefaultTimeout
+
+	cfg, err := configs.Load(os.Args[1:], os.Environ())
+	if err == nil {
+		timeout += 2 * cfg.Peek().StartupRetry.MaxElapsedTime.Duration
+	}
+
+	return fx.StartTimeout(timeout)
 }
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"xm/configs"
+	"xm/pkg/db"
+	"xm/pkg/db/migrations"
+	"xm/pkg/logger"
+
+	"go.uber.org/fx"
+)
+
+const migrateUsage = "usage: xm migrate up|down|status|to <version>"
+
+func migrate(args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	switch args[0] {
+	case "up", "down", "status", "to":
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	var m migrations.Migrator
+
+	return run(
+		func(ctx context.Context) error {
+			return runMigrate(ctx, m, args)
+		},
+		configs.Module,
+		logger.Module,
+		db.Module,
+		fx.Provide(migrations.New),
+		fx.Populate(&m),
+	)
+}
+
+func runMigrate(ctx context.Context, m migrations.Migrator, args []string) error {
+	switch args[0] {
+	case "up":
+		return m.Up(ctx)
+	case "down":
+		return m.Down(ctx)
+	case "to":
+		if len(args) < 2 {
+			return errors.New(migrateUsage)
+		}
+
+		version, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("bad version %q", args[1])
+		}
+
+		return m.To(ctx, version)
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, s := range statuses {
+			state := "pending"
+			if s.Applied {
+				state = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+
+			fmt.Printf("%04d  %-30s  %s\n", s.Version, s.Name, state)
+		}
+
+		return nil
+	default:
+		return errors.New(migrateUsage)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"xm/configs"
+	"xm/pkg/db"
+	"xm/pkg/logger"
+	"xm/pkg/repositories/company"
+
+	"go.uber.org/fx"
+)
+
+var demoCompanies = []company.Company{
+	{Name: "Acme", Code: "ACME", Country: "US", Website: "https://acme.example", Phone: "+1 555 0100"},
+	{Name: "Globex", Code: "GLBX", Country: "GB", Website: "https://globex.example", Phone: "+44 20 7946 0000"},
+	{Name: "Initech", Code: "INTC", Country: "CY", Website: "https://initech.example", Phone: "+357 22 000000"},
+}
+
+// seed loads demo companies into a database that has none yet. The check
+// and the inserts share a transaction, so a failed run leaves nothing behind.
+func seed(args []string) error {
+	var (
+		repo company.Repository
+		txm  db.TxManager
+	)
+
+	return run(
+		func(ctx context.Context) error {
+			var seeded int
+
+			err := txm.Do(ctx, nil, func(ctx context.Context) error {
+				seeded = 0
+
+				_, err := repo.GetAll(ctx, company.Filters{Limit: 1})
+				if err == nil {
+					return nil
+				}
+
+				if err != sql.ErrNoRows {
+					return err
+				}
+
+				for _, c := range demoCompanies {
+					_, err = repo.Create(ctx, c)
+					if err != nil {
+						return err
+					}
+					seeded++
+				}
+
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			if seeded == 0 {
+				fmt.Println("companies already present, nothing to seed")
+				return nil
+			}
+
+			fmt.Printf("seeded %d companies\n", seeded)
+
+			return nil
+		},
+		configs.Module,
+		logger.Module,
+		db.Module,
+		company.Module,
+		fx.Populate(&repo, &txm),
+	)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"xm/configs"
+	"xm/gateways"
+	"xm/pkg/db"
+	"xm/pkg/db/migrations"
+	"xm/pkg/handlers"
+	"xm/pkg/handlers/server"
+	"xm/pkg/health"
+	"xm/pkg/logger"
+	"xm/pkg/repositories"
+	"xm/pkg/services"
+
+	"go.uber.org/fx"
+)
+
+func serve(args []string) error {
+	fx.New(
+		fx.Options(
+			configs.Module,
+			logger.Module,
+			storage(),
+			services.Module,
+			services.Jobs,
+			health.Module,
+			handlers.Module,
+			server.Module,
+			gateways.Module,
+		),
+		startTimeout(),
+	).Run()
+
+	return nil
+}
+
+// storage returns the modules behind the repositories for storage.driver.
+// Like startTimeout it reads the config before the app exists; a config
+// that fails to load is reported by configs.Module instead.
+func storage() fx.Option {
+	cfg, err := configs.Load(os.Args[1:], os.Environ())
+	if err == nil && cfg.Peek().Storage.Driver == "memory" {
+		return repositories.Memory
+	}
+
+	return fx.Options(
+		db.Module,
+		migrations.Module,
+		repositories.Module,
+	)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"xm/configs"
+	"xm/pkg/db"
+	"xm/pkg/handlers"
+	"xm/pkg/logger"
+	userRepository "xm/pkg/repositories/user"
+	userService "xm/pkg/services/user"
+	"xm/pkg/services/utils"
+
+	"go.uber.org/fx"
+)
+
+const userUsage = "usage: xm user create|set-password --username <name> [--password <password>]"
+
+func user(args []string) error {
+	if len(args) == 0 {
+		return errors.New(userUsage)
+	}
+
+	fs := flag.NewFlagSet("user "+args[0], flag.ContinueOnError)
+	username := fs.String("username", "", "user name")
+	password := fs.String("password", "", "password, read from stdin when omitted")
+
+	err := fs.Parse(args[1:])
+	if err != nil {
+		return err
+	}
+
+	if *username == "" {
+		return errors.New(userUsage)
+	}
+
+	if *password == "" {
+		*password, err = readPassword()
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(*password) < 8 {
+		return errors.New("password minimum length should be at least 8")
+	}
+
+	hashed, err := handlers.HashPassword(*password)
+	if err != nil {
+		return err
+	}
+
+	var svc userService.Service
+
+	var fn func(ctx context.Context) error
+	switch args[0] {
+	case "create":
+		fn = func(ctx context.Context) error {
+			err := svc.Create(ctx, &userRepository.User{Username: *username, Password: hashed})
+			if err == utils.ErrAlreadyExists {
+				return fmt.Errorf("user %s already exists", *username)
+			}
+
+			return err
+		}
+	case "set-password":
+		fn = func(ctx context.Context) error {
+			err := svc.SetPassword(ctx, *username, hashed)
+			if err == utils.ErrNotFound {
+				return fmt.Errorf("user %s not found", *username)
+			}
+
+			return err
+		}
+	default:
+		return errors.New(userUsage)
+	}
+
+	return run(
+		fn,
+		configs.Module,
+		logger.Module,
+		db.Module,
+		userRepository.Module,
+		userService.Module,
+		fx.Populate(&svc),
+	)
+}
+
+func readPassword() (string, error) {
+	fmt.Fprint(os.Stderr, "password: ")
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// version is set at build time with -ldflags "-X main.version=v1.2.3".
+var version = "dev"
+
+func printVersion(args []string) error {
+	fmt.Printf("xm %s (%s, %s/%s)\n", version, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	return nil
+}
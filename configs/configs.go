@@ -1,63 +1,299 @@
 package configs
 
 import (
-	"encoding/json"
 	"os"
-	"strings"
+	"sync"
+	"time"
 
 	"go.uber.org/fx"
 )
 
 var Module = fx.Options(
-	fx.Invoke(readFile),
 	fx.Provide(New),
+	fx.Invoke(Validate),
+	fx.Invoke(Watch),
 )
 
-var cfg *configs
-
 type Configs interface {
 	Peek() *configs
+	Source(key string) Source
+	Sources() map[string]Source
+	Reload() error
+	Subscribe(fn func())
+	SubscribeErrors(fn func(err error))
 }
 
 type configs struct {
+	Storage  Storage  `json:"storage"`
 	Database Database `json:"database"`
+	Auth     Auth     `json:"auth"`
+	Server   Server   `json:"server"`
+	NATS     NATS     `json:"nats"`
+	Purge    Purge    `json:"purge"`
+
+	StartupRetry Retry   `json:"startupRetry"`
+	Logging      Logging `json:"logging"`
+	Reload       Reload  `json:"reload"`
+}
+
+// Storage selects what backs the repositories: "postgres", or "memory"
+// for dev runs that keep everything in process and lose it on exit.
+type Storage struct {
+	Driver string `json:"driver"`
 }
 
 type Database struct {
 	Host     string `json:"host"`
 	Port     string `json:"port"`
 	User     string `json:"user"`
-	Password string `json:"password"`
+	Password string `json:"password" secret:"true"`
 	Name     string `json:"name"`
+	SSLMode  string `json:"sslMode"`
+
+	// Schema, when set, becomes the search_path of every connection.
+	Schema string `json:"schema"`
+
+	MaxOpenConns    int      `json:"maxOpenConns"`
+	MaxIdleConns    int      `json:"maxIdleConns"`
+	ConnMaxLifetime Duration `json:"connMaxLifetime"`
+	ConnMaxIdleTime Duration `json:"connMaxIdleTime"`
+	StatsInterval   Duration `json:"statsInterval"`
+	QueryTimeout    Duration `json:"queryTimeout" reload:"true"`
+
+	// SlowQueryThreshold logs queries that take longer; zero disables it.
+	SlowQueryThreshold Duration `json:"slowQueryThreshold" reload:"true"`
+
+	Replicas             []Replica `json:"replicas"`
+	ReplicaCheckInterval Duration  `json:"replicaCheckInterval"`
+	ReplicaCheckTimeout  Duration  `json:"replicaCheckTimeout"`
+
+	// TxRetry is the backoff for transactions that hit a serialization
+	// failure or a deadlock.
+	TxRetry Retry `json:"txRetry"`
+
+	AutoMigrate bool `json:"autoMigrate"`
+}
+
+// Replica is a read-only copy of the primary; it shares the primary's
+// credentials, database name and pool settings.
+type Replica struct {
+	Host string `json:"host"`
+	Port string `json:"port"`
+}
+
+type Auth struct {
+	JWTKey string `json:"jwtKey" secret:"true"`
+
+	// Admins are the usernames allowed to see and restore deleted
+	// companies. Sign-up refuses them, so they are created with
+	// xm user create.
+	Admins []string `json:"admins" reload:"true"`
+}
+
+type Server struct {
+	Addr              string   `json:"addr"`
+	TLS               TLS      `json:"tls"`
+	ReadHeaderTimeout Duration `json:"readHeaderTimeout"`
+	ReadTimeout       Duration `json:"readTimeout"`
+	WriteTimeout      Duration `json:"writeTimeout"`
+	IdleTimeout       Duration `json:"idleTimeout"`
+	MaxHeaderBytes    int      `json:"maxHeaderBytes"`
+	DrainDelay        Duration `json:"drainDelay"`
+}
+
+type TLS struct {
+	CertFile     string `json:"certFile"`
+	KeyFile      string `json:"keyFile"`
+	ClientCAFile string `json:"clientCAFile"`
+	ClientAuth   string `json:"clientAuth"`
+}
+
+type NATS struct {
+	URLs            []string `json:"urls"`
+	Name            string   `json:"name"`
+	User            string   `json:"user"`
+	Password        string   `json:"password" secret:"true"`
+	Token           string   `json:"token" secret:"true"`
+	CredentialsFile string   `json:"credentialsFile"`
+	NKeySeedFile    string   `json:"nkeySeedFile"`
+	TLS             NATSTLS  `json:"tls"`
+	ConnectTimeout  Duration `json:"connectTimeout"`
+	MaxReconnects   int      `json:"maxReconnects"`
+	ReconnectWait   Duration `json:"reconnectWait"`
+	ReconnectJitter Duration `json:"reconnectJitter"`
+	DrainTimeout    Duration `json:"drainTimeout"`
+}
+
+type NATSTLS struct {
+	CAFile   string `json:"caFile"`
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+}
+
+// Retry is an exponential backoff, e.g. the one used while waiting for
+// dependencies on startup.
+type Retry struct {
+	InitialInterval Duration `json:"initialInterval"`
+	MaxInterval     Duration `json:"maxInterval"`
+	Multiplier      float64  `json:"multiplier"`
+	Jitter          float64  `json:"jitter"`
+	MaxElapsedTime  Duration `json:"maxElapsedTime"`
+}
+
+type Logging struct {
+	Level    string   `json:"level" reload:"true"`
+	Encoding string   `json:"encoding"`
+	Outputs  []string `json:"outputs"`
+	Rotation Rotation `json:"rotation"`
+}
+
+// Rotation applies to file outputs. A file is rotated once it grows past
+// MaxSizeMB or gets older than MaxAge; zero disables either trigger.
+// Rotated files beyond MaxBackups or older than Retention are removed.
+type Rotation struct {
+	MaxSizeMB  int      `json:"maxSizeMB"`
+	MaxAge     Duration `json:"maxAge"`
+	MaxBackups int      `json:"maxBackups"`
+	Retention  Duration `json:"retention"`
+}
+
+// Purge hard-deletes companies deleted more than Retention ago, checking
+// every Interval and removing at most BatchSize per statement. A zero
+// Retention keeps them forever; DryRun only logs how many would go.
+type Purge struct {
+	Retention Duration `json:"retention" reload:"true"`
+	Interval  Duration `json:"interval"`
+	BatchSize int      `json:"batchSize" reload:"true"`
+	DryRun    bool     `json:"dryRun" reload:"true"`
+}
+
+type Reload struct {
+	Watch    bool     `json:"watch"`
+	Interval Duration `json:"interval"`
+}
+
+type store struct {
+	mu      sync.RWMutex
+	cfg     *configs
+	sources map[string]Source
+
+	path    string
+	args    []string
+	environ []string
+
+	subscribers      []func()
+	errorSubscribers []func(err error)
 }
 
 type Params struct {
 	fx.In
 }
 
-func New(p Params) Configs {
-	return &configs{}
+func New(p Params) (Configs, error) {
+	return Load(os.Args[1:], os.Environ())
 }
 
-func (c *configs) Peek() *configs {
-	return cfg
-}
+func defaults() *configs {
+	return &configs{
+		Storage: Storage{
+			Driver: "postgres",
+		},
+		Database: Database{
+			Host: "localhost",
+			Port: "5432",
+			User: "postgres",
+			Name: "demo",
+
+			SSLMode:         "disable",
+			MaxOpenConns:    25,
+			MaxIdleConns:    25,
+			ConnMaxLifetime: Duration{30 * time.Minute},
+			ConnMaxIdleTime: Duration{5 * time.Minute},
+			StatsInterval:   Duration{time.Minute},
+			QueryTimeout:    Duration{5 * time.Second},
 
-func readFile() *configs {
-	wd, _ := os.Getwd()
-	index := strings.LastIndex(wd, "xm")
-	wd = wd[:index]
+			SlowQueryThreshold: Duration{200 * time.Millisecond},
 
-	f, err := os.Open(wd + "xm/configs/configs.json")
-	if err != nil {
-		panic(err)
+			ReplicaCheckInterval: Duration{5 * time.Second},
+			ReplicaCheckTimeout:  Duration{time.Second},
+
+			TxRetry: Retry{
+				InitialInterval: Duration{10 * time.Millisecond},
+				MaxInterval:     Duration{200 * time.Millisecond},
+				Multiplier:      2,
+				Jitter:          0.5,
+				MaxElapsedTime:  Duration{2 * time.Second},
+			},
+		},
+		Server: Server{
+			Addr:              ":8081",
+			ReadHeaderTimeout: Duration{5 * time.Second},
+			ReadTimeout:       Duration{15 * time.Second},
+			WriteTimeout:      Duration{15 * time.Second},
+			IdleTimeout:       Duration{60 * time.Second},
+			MaxHeaderBytes:    1 << 20,
+			TLS: TLS{
+				ClientAuth: "none",
+			},
+		},
+		NATS: NATS{
+			URLs:            []string{"nats://localhost:4222"},
+			Name:            "xm",
+			ConnectTimeout:  Duration{2 * time.Second},
+			MaxReconnects:   -1,
+			ReconnectWait:   Duration{2 * time.Second},
+			ReconnectJitter: Duration{500 * time.Millisecond},
+			DrainTimeout:    Duration{30 * time.Second},
+		},
+		StartupRetry: Retry{
+			InitialInterval: Duration{500 * time.Millisecond},
+			MaxInterval:     Duration{10 * time.Second},
+			Multiplier:      2,
+			Jitter:          0.2,
+			MaxElapsedTime:  Duration{time.Minute},
+		},
+		Logging: Logging{
+			Level:    "info",
+			Encoding: "json",
+			Outputs:  []string{"stdout"},
+			Rotation: Rotation{
+				MaxSizeMB:  100,
+				MaxBackups: 5,
+			},
+		},
+		Purge: Purge{
+			Interval:  Duration{time.Hour},
+			BatchSize: 500,
+		},
+		Reload: Reload{
+			Watch:    true,
+			Interval: Duration{2 * time.Second},
+		},
 	}
-	defer f.Close()
+}
+
+func (s *store) Peek() *configs {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.cfg
+}
+
+func (s *store) Source(key string) Source {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.sources[key]
+}
+
+func (s *store) Sources() map[string]Source {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	err = json.NewDecoder(f).Decode(&cfg)
-	if err != nil {
-		panic(err)
+	sources := make(map[string]Source, len(s.sources))
+	for k, v := range s.sources {
+		sources[k] = v
 	}
 
-	return cfg
+	return sources
 }
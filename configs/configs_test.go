@@ -0,0 +1,206 @@
+package configs_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"xm/configs"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := configs.Load([]string{"--config", writeFile(t, `{}`)}, nil)
+	require.NoError(t, err)
+
+	require.Equal(t, "localhost", cfg.Peek().Database.Host)
+	require.Equal(t, configs.SourceDefault, cfg.Source("database.host"))
+}
+
+func TestLoadLayers(t *testing.T) {
+	path := writeFile(t, `{"database": {"host": "file-host", "port": "6543", "user": "file-user"}}`)
+
+	cfg, err := configs.Load(
+		[]string{"serve", "--config=" + path, "--database.user", "flag-user", "--unknown", "x"},
+		[]string{"XM_DATABASE_PORT=7654", "XM_DATABASE_USER=env-user", "HOME=/root"},
+	)
+	require.NoError(t, err)
+
+	db := cfg.Peek().Database
+	require.Equal(t, "file-host", db.Host)
+	require.Equal(t, "7654", db.Port)
+	require.Equal(t, "flag-user", db.User)
+	require.Equal(t, "demo", db.Name)
+
+	require.Equal(t, configs.SourceFile, cfg.Source("database.host"))
+	require.Equal(t, configs.SourceEnv, cfg.Source("database.port"))
+	require.Equal(t, configs.SourceFlag, cfg.Source("database.user"))
+	require.Equal(t, configs.SourceDefault, cfg.Source("database.name"))
+}
+
+func TestLoadConfigFromEnv(t *testing.T) {
+	path := writeFile(t, `{"database": {"name": "from-env-file"}}`)
+
+	cfg, err := configs.Load(nil, []string{"XM_CONFIG=" + path})
+	require.NoError(t, err)
+
+	require.Equal(t, "from-env-file", cfg.Peek().Database.Name)
+}
+
+func TestLoadErrors(t *testing.T) {
+	_, err := configs.Load([]string{"--config", filepath.Join(t.TempDir(), "missing.json")}, nil)
+	require.Error(t, err)
+
+	_, err = configs.Load([]string{"--config", writeFile(t, `{"databse": {}}`)}, nil)
+	require.Error(t, err)
+
+	_, err = configs.Load([]string{"--database.host"}, nil)
+	require.Error(t, err)
+}
+
+func TestValidate(t *testing.T) {
+	cfg, err := configs.Load([]string{"--config", writeFile(t, `{"database": {"host": " ", "port": "70000", "name": ""}}`)}, nil)
+	require.NoError(t, err)
+
+	err = configs.Validate(cfg)
+
+	var verr *configs.ValidationError
+	require.ErrorAs(t, err, &verr)
+	require.Equal(t, []string{
+		"database.host: is required",
+		`database.port: must be a port number between 1 and 65535, got "70000"`,
+		"database.name: is required",
+		"auth.jwtKey: is required",
+	}, verr.Problems)
+
+	cfg, err = configs.Load([]string{"--config", writeFile(t, `{"auth": {"jwtKey": "key"}}`)}, nil)
+	require.NoError(t, err)
+	require.NoError(t, configs.Validate(cfg))
+}
+
+func TestValidateServer(t *testing.T) {
+	cfg, err := configs.Load([]string{
+		"--config", writeFile(t, `{"auth": {"jwtKey": "key"}, "server": {"addr": "8081", "readTimeout": "0s", "tls": {"keyFile": "key.pem"}}}`),
+	}, nil)
+	require.NoError(t, err)
+
+	var verr *configs.ValidationError
+	require.ErrorAs(t, configs.Validate(cfg), &verr)
+	require.Equal(t, []string{
+		`server.addr: must be host:port, got "8081"`,
+		`server.readTimeout: must be a positive duration, got "0s"`,
+		"server.tls: certFile and keyFile must be set together",
+	}, verr.Problems)
+}
+
+func TestValidatePurge(t *testing.T) {
+	cfg, err := configs.Load([]string{
+		"--config", writeFile(t, `{"auth": {"jwtKey": "key"}, "purge": {"retention": "-1h", "interval": "0s", "batchSize": 0}}`),
+	}, nil)
+	require.NoError(t, err)
+
+	var verr *configs.ValidationError
+	require.ErrorAs(t, configs.Validate(cfg), &verr)
+	require.Equal(t, []string{
+		"purge.retention: must not be negative",
+		`purge.interval: must be a positive duration, got "0s"`,
+		"purge.batchSize: must be at least 1",
+	}, verr.Problems)
+}
+
+func TestReload(t *testing.T) {
+	path := writeFile(t, `{"database": {"host": "db1"}, "auth": {"jwtKey": "key"}, "logging": {"level": "info"}}`)
+
+	cfg, err := configs.Load([]string{"--config", path}, nil)
+	require.NoError(t, err)
+
+	var notified int
+	cfg.Subscribe(func() { notified++ })
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"database": {"host": "db2"}, "auth": {"jwtKey": "key"}, "logging": {"level": "debug"}}`), 0o600))
+
+	err = cfg.Reload()
+
+	var rerr *configs.RestartError
+	require.ErrorAs(t, err, &rerr)
+	require.Equal(t, []string{"database.host"}, rerr.Keys)
+	require.Equal(t, 1, notified)
+	require.Equal(t, "debug", cfg.Peek().Logging.Level)
+	require.Equal(t, "db1", cfg.Peek().Database.Host)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"database": {"host": "db1"}, "auth": {"jwtKey": "key"}, "logging": {"level": "verbose"}}`), 0o600))
+
+	var verr *configs.ValidationError
+	require.ErrorAs(t, cfg.Reload(), &verr)
+	require.Equal(t, 1, notified)
+	require.Equal(t, "debug", cfg.Peek().Logging.Level)
+
+	// Valid on its own, but the restart-only driver stays postgres,
+	// which checks the query timeout.
+	require.NoError(t, os.WriteFile(path, []byte(`{"storage": {"driver": "memory"}, "database": {"host": "db1", "queryTimeout": "-1s"}, "auth": {"jwtKey": "key"}, "logging": {"level": "debug"}}`), 0o600))
+
+	require.ErrorAs(t, cfg.Reload(), &verr)
+	require.Equal(t, 1, notified)
+	require.Equal(t, "postgres", cfg.Peek().Storage.Driver)
+	require.Positive(t, cfg.Peek().Database.QueryTimeout.Duration)
+}
+
+func TestSecrets(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "db_password")
+	require.NoError(t, os.WriteFile(secret, []byte("s3cret\n"), 0o600))
+
+	cfg, err := configs.Load(
+		[]string{"--config", writeFile(t, `{"database": {"password": "file:`+secret+`"}}`)},
+		[]string{"XM_AUTH_JWT_KEY=env:JWT", "JWT=signing-key"},
+	)
+	require.NoError(t, err)
+
+	require.Equal(t, "s3cret", cfg.Peek().Database.Password)
+	require.Equal(t, "signing-key", cfg.Peek().Auth.JWTKey)
+
+	for _, s := range configs.Dump(cfg) {
+		require.NotContains(t, s.Value, "s3cret")
+		require.NotContains(t, s.Value, "signing-key")
+	}
+	require.NotContains(t, cfg.Peek().String(), "s3cret")
+
+	cfg, err = configs.Load([]string{"--config", writeFile(t, `{"auth": {"jwtKey": "file:jwt_key"}}`)}, nil)
+	require.Error(t, err)
+
+	path := writeFile(t, `{"auth": {"jwtKey": "file:jwt_key"}}`)
+	require.NoError(t, os.WriteFile(filepath.Join(filepath.Dir(path), "jwt_key"), []byte("from-dir\n"), 0o600))
+
+	cfg, err = configs.Load([]string{"--config", path}, nil)
+	require.NoError(t, err)
+	require.Equal(t, "from-dir", cfg.Peek().Auth.JWTKey)
+}
+
+func TestStripFlags(t *testing.T) {
+	args := configs.StripFlags([]string{"migrate", "--config", "x.json", "to", "--database.host=db", "3", "-v"})
+	require.Equal(t, []string{"migrate", "to", "3", "-v"}, args)
+
+	args = configs.StripFlags([]string{"user", "--database.host", "db", "--", "--database.name", "x"})
+	require.Equal(t, []string{"user", "--", "--database.name", "x"}, args)
+
+	cfg, err := configs.Load([]string{"--config", writeFile(t, `{}`), "--", "--database.name", "x"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, "demo", cfg.Peek().Database.Name)
+}
+
+func TestBoolFlags(t *testing.T) {
+	args := []string{"--database.autoMigrate", "serve", "--purge.dryRun=false"}
+
+	cfg, err := configs.Load(append([]string{"--config", writeFile(t, `{"purge": {"dryRun": true}}`)}, args...), nil)
+	require.NoError(t, err)
+	require.True(t, cfg.Peek().Database.AutoMigrate)
+	require.False(t, cfg.Peek().Purge.DryRun)
+
+	require.Equal(t, []string{"serve"}, configs.StripFlags(args))
+}
+
+func writeFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "configs.json")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	return path
+}
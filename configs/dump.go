@@ -0,0 +1,62 @@
+package configs
+
+import (
+	"encoding"
+	"fmt"
+	"strings"
+	"xm/configs/secrets"
+)
+
+type Setting struct {
+	Key    string
+	Value  string
+	Source Source
+}
+
+// Dump lists every setting with the layer it came from. Secrets are
+// redacted, so the result is safe to log or print.
+func Dump(c Configs) []Setting {
+	cfg := c.Peek()
+	sources := c.Sources()
+	fields := fieldsOf(cfg)
+
+	settings := make([]Setting, 0, len(fields))
+	for _, key := range sortedKeys(fields) {
+		f := fields[key]
+
+		value := format(f)
+		if f.secret && value != "" {
+			value = secrets.Redacted
+		}
+
+		settings = append(settings, Setting{
+			Key:    key,
+			Value:  value,
+			Source: sources[key],
+		})
+	}
+
+	return settings
+}
+
+func format(f field) string {
+	if m, ok := f.value.Interface().(encoding.TextMarshaler); ok {
+		text, _ := m.MarshalText()
+		return string(text)
+	}
+
+	return fmt.Sprint(f.value.Interface())
+}
+
+// String keeps secrets out of logs when the whole configuration is printed.
+func (c *configs) String() string {
+	var b strings.Builder
+	for i, setting := range Dump(&store{cfg: c}) {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(setting.Key + "=" + setting.Value)
+	}
+
+	return b.String()
+}
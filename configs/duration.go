@@ -0,0 +1,23 @@
+package configs
+
+import "time"
+
+// Duration is a time.Duration written as "5s" or "1m30s" in config files.
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+
+	d.Duration = v
+
+	return nil
+}
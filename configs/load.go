@@ -0,0 +1,432 @@
+package configs
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"xm/configs/secrets"
+)
+
+type Source string
+
+const (
+	SourceDefault Source = "default"
+	SourceFile    Source = "file"
+	SourceEnv     Source = "env"
+	SourceFlag    Source = "flag"
+)
+
+const (
+	envPrefix   = "XM_"
+	envConfig   = "XM_CONFIG"
+	flagConfig  = "config"
+	defaultPath = "configs/configs.json"
+)
+
+// Load merges built-in defaults, the config file, XM_* environment variables
+// and command-line flags, each layer overriding the previous one.
+// The file is taken from --config or XM_CONFIG; without either, the nearest
+// configs/configs.json above the working directory is used if there is one.
+func Load(args, environ []string) (Configs, error) {
+	cfg, sources, path, err := load(args, environ)
+	if err != nil {
+		return nil, err
+	}
+
+	return &store{
+		cfg:     cfg,
+		sources: sources,
+		path:    path,
+		args:    args,
+		environ: environ,
+	}, nil
+}
+
+func load(args, environ []string) (cfg *configs, sources map[string]Source, path string, err error) {
+	cfg = defaults()
+	fields := fieldsOf(cfg)
+
+	sources = make(map[string]Source, len(fields))
+	for key := range fields {
+		sources[key] = SourceDefault
+	}
+
+	path, flags, err := parseFlags(args, fields)
+	if err != nil {
+		return
+	}
+
+	env := parseEnv(environ)
+
+	if path == "" {
+		path = env[envConfig]
+	}
+
+	if path == "" {
+		path = findFile(defaultPath)
+	}
+
+	if path != "" {
+		err = readFile(path, cfg, fields, sources)
+		if err != nil {
+			return
+		}
+	}
+
+	for _, key := range sortedKeys(fields) {
+		value, ok := env[envName(key)]
+		if !ok {
+			continue
+		}
+
+		err = setValue(fields[key].value, value)
+		if err != nil {
+			err = fmt.Errorf("configs: %s: %w", envName(key), err)
+			return
+		}
+
+		sources[key] = SourceEnv
+	}
+
+	for _, key := range sortedKeys(fields) {
+		value, ok := flags[key]
+		if !ok {
+			continue
+		}
+
+		err = setValue(fields[key].value, value)
+		if err != nil {
+			err = fmt.Errorf("configs: --%s: %w", key, err)
+			return
+		}
+
+		sources[key] = SourceFlag
+	}
+
+	for _, key := range sortedKeys(fields) {
+		f := fields[key]
+		if !f.secret {
+			continue
+		}
+
+		// Relative files named in the config file are found next to it.
+		dir := ""
+		if sources[key] == SourceFile {
+			dir = filepath.Dir(path)
+		}
+
+		var value string
+		value, err = secrets.Default(dir, environ).Resolve(f.value.String())
+		if err != nil {
+			err = fmt.Errorf("configs: %s: %w", key, err)
+			return
+		}
+
+		f.value.SetString(value)
+	}
+
+	return
+}
+
+func readFile(path string, cfg *configs, fields map[string]field, sources map[string]Source) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("configs: %w", err)
+	}
+
+	var raw map[string]interface{}
+	err = json.Unmarshal(data, &raw)
+	if err != nil {
+		return fmt.Errorf("configs: %s: %w", path, err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	err = dec.Decode(cfg)
+	if err != nil {
+		return fmt.Errorf("configs: %s: %w", path, err)
+	}
+
+	for _, key := range flatten("", raw, fields) {
+		sources[key] = SourceFile
+	}
+
+	return nil
+}
+
+func flatten(prefix string, raw map[string]interface{}, fields map[string]field) (keys []string) {
+	for k, v := range raw {
+		key := prefix + k
+		if _, ok := fields[key]; ok {
+			keys = append(keys, key)
+			continue
+		}
+
+		if m, ok := v.(map[string]interface{}); ok {
+			keys = append(keys, flatten(key+".", m, fields)...)
+		}
+	}
+
+	return
+}
+
+func findFile(name string) string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+
+	for {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+
+		dir = parent
+	}
+}
+
+type field struct {
+	value  reflect.Value
+	reload bool
+	secret bool
+}
+
+// fieldsOf maps every leaf setting of cfg to its dotted json key,
+// e.g. "database.host". Settings tagged `reload:"true"` are safe to
+// change while the process runs; settings tagged `secret:"true"` may hold
+// a secret reference such as "file:/run/secrets/db_password" and are
+// never printed.
+func fieldsOf(cfg *configs) map[string]field {
+	fields := make(map[string]field)
+	collect("", reflect.ValueOf(cfg).Elem(), fields)
+
+	return fields
+}
+
+func collect(prefix string, v reflect.Value, fields map[string]field) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Struct && !isText(fv) {
+			collect(prefix+name+".", fv, fields)
+			continue
+		}
+
+		fields[prefix+name] = field{
+			value:  fv,
+			reload: t.Field(i).Tag.Get("reload") == "true",
+			secret: t.Field(i).Tag.Get("secret") == "true",
+		}
+	}
+}
+
+func isText(v reflect.Value) bool {
+	_, ok := v.Addr().Interface().(encoding.TextUnmarshaler)
+	return ok
+}
+
+func setValue(v reflect.Value, s string) error {
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(s))
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return json.Unmarshal([]byte(s), v.Addr().Interface())
+		}
+
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return json.Unmarshal([]byte(s), v.Addr().Interface())
+	}
+
+	return nil
+}
+
+// envName turns a dotted key into its environment variable,
+// e.g. "database.maxOpenConns" into "XM_DATABASE_MAX_OPEN_CONNS".
+func envName(key string) string {
+	var b strings.Builder
+	b.WriteString(envPrefix)
+
+	var prev rune
+	for _, r := range key {
+		switch {
+		case r == '.':
+			b.WriteByte('_')
+		case unicode.IsUpper(r) && (unicode.IsLower(prev) || unicode.IsDigit(prev)):
+			b.WriteByte('_')
+			b.WriteRune(r)
+		default:
+			b.WriteRune(unicode.ToUpper(r))
+		}
+		prev = r
+	}
+
+	return b.String()
+}
+
+func parseEnv(environ []string) map[string]string {
+	env := make(map[string]string)
+	for _, kv := range environ {
+		i := strings.IndexByte(kv, '=')
+		if i < 0 || !strings.HasPrefix(kv, envPrefix) {
+			continue
+		}
+
+		env[kv[:i]] = kv[i+1:]
+	}
+
+	return env
+}
+
+// parseFlags picks --config and --<key> flags out of args and leaves
+// everything else, such as subcommands and their flags, alone. A bool
+// flag given without =value is set to true and takes no argument.
+func parseFlags(args []string, fields map[string]field) (path string, flags map[string]string, err error) {
+	flags = make(map[string]string)
+
+	for i := 0; i < len(args); i++ {
+		name, value, hasValue, ok := splitFlag(args[i])
+		if !ok {
+			if args[i] == "--" {
+				break
+			}
+			continue
+		}
+
+		if _, known := fields[name]; !known && name != flagConfig {
+			continue
+		}
+
+		if !hasValue && isBool(fields, name) {
+			value, hasValue = "true", true
+		}
+
+		if !hasValue {
+			if i+1 >= len(args) {
+				return "", nil, errors.New("configs: flag needs an argument: --" + name)
+			}
+
+			i++
+			value = args[i]
+		}
+
+		if name == flagConfig {
+			path = value
+			continue
+		}
+
+		flags[name] = value
+	}
+
+	return
+}
+
+// StripFlags returns args without the flags Load understands, so
+// commands can parse what is left on their own. Everything from a "--"
+// on is left as it is.
+func StripFlags(args []string) []string {
+	fields := fieldsOf(defaults())
+
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--" {
+			return append(rest, args[i:]...)
+		}
+
+		name, _, hasValue, ok := splitFlag(args[i])
+		if _, known := fields[name]; !ok || (!known && name != flagConfig) {
+			rest = append(rest, args[i])
+			continue
+		}
+
+		if !hasValue && !isBool(fields, name) {
+			i++
+		}
+	}
+
+	return rest
+}
+
+func isBool(fields map[string]field, name string) bool {
+	f, ok := fields[name]
+	return ok && f.value.Kind() == reflect.Bool
+}
+
+func splitFlag(arg string) (name, value string, hasValue, ok bool) {
+	if len(arg) < 2 || arg[0] != '-' || arg == "--" {
+		return
+	}
+
+	name = strings.TrimPrefix(arg[1:], "-")
+	if i := strings.IndexByte(name, '='); i >= 0 {
+		return name[:i], name[i+1:], true, true
+	}
+
+	return name, "", false, true
+}
+
+func sortedKeys(fields map[string]field) []string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
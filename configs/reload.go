@@ -0,0 +1,198 @@
+package configs
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.uber.org/fx"
+)
+
+// RestartError reports settings that changed on reload but only take
+// effect after a restart.
+type RestartError struct {
+	Keys []string
+}
+
+func (e *RestartError) Error() string {
+	return "configs: restart required to apply " + strings.Join(e.Keys, ", ")
+}
+
+// Reload re-reads every layer and applies the settings that are safe to
+// change at runtime; the rest keep their current values. A configuration
+// that fails to load or validate, on its own or merged with the current
+// one, is rejected and the current one stays.
+func (s *store) Reload() error {
+	next, nextSources, _, err := load(s.args, s.environ)
+	if err != nil {
+		return err
+	}
+
+	err = next.Validate()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+
+	merged := *s.cfg
+	sources := make(map[string]Source, len(s.sources))
+	for k, v := range s.sources {
+		sources[k] = v
+	}
+
+	current, updated, target := fieldsOf(s.cfg), fieldsOf(next), fieldsOf(&merged)
+
+	var changed bool
+	var restart []string
+	for _, key := range sortedKeys(updated) {
+		if reflect.DeepEqual(current[key].value.Interface(), updated[key].value.Interface()) {
+			continue
+		}
+
+		if !updated[key].reload {
+			restart = append(restart, key)
+			continue
+		}
+
+		target[key].value.Set(updated[key].value)
+		sources[key] = nextSources[key]
+		changed = true
+	}
+
+	if changed {
+		err = merged.Validate()
+		if err != nil {
+			s.mu.Unlock()
+			return err
+		}
+	}
+
+	var subscribers []func()
+	if changed {
+		s.cfg = &merged
+		s.sources = sources
+		subscribers = append(subscribers, s.subscribers...)
+	}
+
+	s.mu.Unlock()
+
+	for _, fn := range subscribers {
+		fn()
+	}
+
+	if len(restart) > 0 {
+		return &RestartError{Keys: restart}
+	}
+
+	return nil
+}
+
+// Subscribe registers fn to be called every time a reload changes the
+// configuration; fn reads the new values through Peek.
+func (s *store) Subscribe(fn func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.subscribers = append(s.subscribers, fn)
+}
+
+// SubscribeErrors registers fn to be called when a background reload
+// is rejected or needs a restart.
+func (s *store) SubscribeErrors(fn func(err error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.errorSubscribers = append(s.errorSubscribers, fn)
+}
+
+func (s *store) notifyErrors(err error) {
+	s.mu.RLock()
+	subscribers := append([]func(err error){}, s.errorSubscribers...)
+	s.mu.RUnlock()
+
+	for _, fn := range subscribers {
+		fn(err)
+	}
+}
+
+// Watch reloads the configuration on SIGHUP and, when reload.watch is on,
+// whenever the config file changes on disk.
+func Watch(lc fx.Lifecycle, c Configs) {
+	s, ok := c.(*store)
+	if !ok {
+		return
+	}
+
+	hup := make(chan os.Signal, 1)
+	done := make(chan struct{})
+
+	lc.Append(
+		fx.Hook{
+			OnStart: func(ctx context.Context) error {
+				signal.Notify(hup, syscall.SIGHUP)
+				go s.watch(hup, done)
+				return nil
+			},
+			OnStop: func(ctx context.Context) error {
+				signal.Stop(hup)
+				close(done)
+				return nil
+			},
+		},
+	)
+}
+
+func (s *store) watch(hup <-chan os.Signal, done <-chan struct{}) {
+	cfg := s.Peek().Reload
+
+	var tick <-chan time.Time
+	if cfg.Watch && s.path != "" {
+		ticker := time.NewTicker(cfg.Interval.Duration)
+		defer ticker.Stop()
+
+		tick = ticker.C
+	}
+
+	last := statFile(s.path)
+	for {
+		select {
+		case <-done:
+			return
+		case <-hup:
+			last = statFile(s.path)
+		case <-tick:
+			current := statFile(s.path)
+			if current == last {
+				continue
+			}
+			last = current
+		}
+
+		err := s.Reload()
+		if err != nil {
+			s.notifyErrors(err)
+		}
+	}
+}
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+func statFile(path string) fileState {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileState{}
+	}
+
+	return fileState{
+		modTime: info.ModTime(),
+		size:    info.Size(),
+	}
+}
@@ -0,0 +1,78 @@
+package secrets
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const Redacted = "******"
+
+// Provider looks up a secret by name, e.g. a file path or a variable name.
+type Provider interface {
+	Get(name string) (string, error)
+}
+
+// Resolver maps reference schemes to providers. A value such as
+// "file:/run/secrets/db_password" is resolved by the "file" provider;
+// values without a known scheme are returned as they are.
+type Resolver map[string]Provider
+
+// Default resolves file: references, relative ones against dir, and env:
+// references against environ.
+func Default(dir string, environ []string) Resolver {
+	return Resolver{
+		"file": File{Dir: dir},
+		"env":  Env(environ),
+	}
+}
+
+func (r Resolver) Resolve(value string) (string, error) {
+	i := strings.IndexByte(value, ':')
+	if i < 0 {
+		return value, nil
+	}
+
+	p, ok := r[value[:i]]
+	if !ok {
+		return value, nil
+	}
+
+	return p.Get(value[i+1:])
+}
+
+// File reads a secret from a file, as mounted by docker secrets.
+// A single trailing newline is dropped. Relative paths are taken from Dir.
+type File struct {
+	Dir string
+}
+
+func (f File) Get(name string) (string, error) {
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(f.Dir, name)
+	}
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return "", fmt.Errorf("secrets: %w", err)
+	}
+
+	s := strings.TrimSuffix(string(data), "\n")
+	s = strings.TrimSuffix(s, "\r")
+
+	return s, nil
+}
+
+// Env reads a secret from a list of KEY=value environment entries.
+type Env []string
+
+func (e Env) Get(name string) (string, error) {
+	for _, kv := range e {
+		if strings.HasPrefix(kv, name+"=") {
+			return kv[len(name)+1:], nil
+		}
+	}
+
+	return "", fmt.Errorf("secrets: environment variable %s is not set", name)
+}
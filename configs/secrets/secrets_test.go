@@ -0,0 +1,40 @@
+package secrets_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"xm/configs/secrets"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolve(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "db_password")
+	require.NoError(t, os.WriteFile(path, []byte("from-file\n"), 0o600))
+
+	r := secrets.Default(dir, []string{"XM_JWT_KEY=from-env"})
+
+	v, err := r.Resolve("file:" + path)
+	require.NoError(t, err)
+	require.Equal(t, "from-file", v)
+
+	v, err = r.Resolve("file:db_password")
+	require.NoError(t, err)
+	require.Equal(t, "from-file", v)
+
+	v, err = r.Resolve("env:XM_JWT_KEY")
+	require.NoError(t, err)
+	require.Equal(t, "from-env", v)
+
+	v, err = r.Resolve("plain:text")
+	require.NoError(t, err)
+	require.Equal(t, "plain:text", v)
+
+	_, err = r.Resolve("env:MISSING")
+	require.Error(t, err)
+
+	_, err = r.Resolve("file:" + filepath.Join(t.TempDir(), "missing"))
+	require.Error(t, err)
+}
@@ -0,0 +1,340 @@
+package configs
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var identifier = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// ValidationError carries every problem found in a configuration,
+// so they can all be fixed in one go.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  " + strings.Join(e.Problems, "\n  ")
+}
+
+func Validate(c Configs) error {
+	return c.Peek().Validate()
+}
+
+func (c *configs) Validate() error {
+	var v validator
+
+	c.Storage.validate(&v)
+	if c.Storage.Driver == "postgres" {
+		c.Database.validate(&v)
+	}
+	c.Auth.validate(&v)
+	c.Server.validate(&v)
+	c.NATS.validate(&v)
+	c.Purge.validate(&v)
+	c.StartupRetry.validate(&v, "startupRetry")
+	c.Logging.validate(&v)
+	c.Reload.validate(&v)
+
+	return v.err()
+}
+
+func (s Storage) validate(v *validator) {
+	v.oneOf("storage.driver", s.Driver, "postgres", "memory")
+}
+
+func (d Database) validate(v *validator) {
+	v.required("database.host", d.Host)
+	v.port("database.port", d.Port)
+	v.required("database.user", d.User)
+	v.required("database.name", d.Name)
+	v.oneOf("database.sslMode", d.SSLMode, "disable", "allow", "prefer", "require", "verify-ca", "verify-full")
+
+	if d.Schema != "" && !identifier.MatchString(d.Schema) {
+		v.add("database.schema", fmt.Sprintf("must be a lower-case identifier, got %q", d.Schema))
+	}
+
+	if d.MaxOpenConns < 0 {
+		v.add("database.maxOpenConns", "must not be negative")
+	}
+
+	if d.MaxIdleConns < 0 {
+		v.add("database.maxIdleConns", "must not be negative")
+	}
+
+	if d.MaxOpenConns > 0 && d.MaxIdleConns > d.MaxOpenConns {
+		v.add("database.maxIdleConns", "must not exceed maxOpenConns")
+	}
+
+	if d.ConnMaxLifetime.Duration < 0 {
+		v.add("database.connMaxLifetime", "must not be negative")
+	}
+
+	if d.ConnMaxIdleTime.Duration < 0 {
+		v.add("database.connMaxIdleTime", "must not be negative")
+	}
+
+	if d.StatsInterval.Duration < 0 {
+		v.add("database.statsInterval", "must not be negative")
+	}
+
+	if d.QueryTimeout.Duration < 0 {
+		v.add("database.queryTimeout", "must not be negative")
+	}
+
+	if d.SlowQueryThreshold.Duration < 0 {
+		v.add("database.slowQueryThreshold", "must not be negative")
+	}
+
+	for i, r := range d.Replicas {
+		key := "database.replicas[" + strconv.Itoa(i) + "]"
+		v.required(key+".host", r.Host)
+		v.port(key+".port", r.Port)
+	}
+
+	if len(d.Replicas) > 0 {
+		v.positive("database.replicaCheckInterval", d.ReplicaCheckInterval)
+		v.positive("database.replicaCheckTimeout", d.ReplicaCheckTimeout)
+	}
+
+	d.TxRetry.validate(v, "database.txRetry")
+}
+
+func (a Auth) validate(v *validator) {
+	v.required("auth.jwtKey", a.JWTKey)
+}
+
+func (s Server) validate(v *validator) {
+	v.addr("server.addr", s.Addr)
+	v.positive("server.readHeaderTimeout", s.ReadHeaderTimeout)
+	v.positive("server.readTimeout", s.ReadTimeout)
+	v.positive("server.writeTimeout", s.WriteTimeout)
+	v.positive("server.idleTimeout", s.IdleTimeout)
+
+	if s.MaxHeaderBytes <= 0 {
+		v.add("server.maxHeaderBytes", "must be positive")
+	}
+
+	if s.DrainDelay.Duration < 0 {
+		v.add("server.drainDelay", "must not be negative")
+	}
+
+	s.TLS.validate(v)
+}
+
+func (t TLS) validate(v *validator) {
+	v.oneOf("server.tls.clientAuth", t.ClientAuth, "none", "request", "require", "verify-if-given", "require-and-verify")
+
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		v.add("server.tls", "certFile and keyFile must be set together")
+	}
+
+	if t.CertFile == "" {
+		if t.ClientCAFile != "" || t.ClientAuth != "none" {
+			v.add("server.tls", "client certificates need certFile and keyFile")
+		}
+		return
+	}
+
+	v.file("server.tls.certFile", t.CertFile)
+	v.file("server.tls.keyFile", t.KeyFile)
+
+	if t.ClientCAFile != "" {
+		v.file("server.tls.clientCAFile", t.ClientCAFile)
+	}
+
+	if (t.ClientAuth == "verify-if-given" || t.ClientAuth == "require-and-verify") && t.ClientCAFile == "" {
+		v.add("server.tls.clientCAFile", "is required when clientAuth is "+strconv.Quote(t.ClientAuth))
+	}
+}
+
+func (n NATS) validate(v *validator) {
+	if len(n.URLs) == 0 {
+		v.add("nats.urls", "is required")
+	}
+
+	for _, u := range n.URLs {
+		v.url("nats.urls", u, "nats", "tls", "ws", "wss")
+	}
+
+	var auth int
+	for _, set := range []bool{n.User != "", n.Token != "", n.CredentialsFile != "", n.NKeySeedFile != ""} {
+		if set {
+			auth++
+		}
+	}
+
+	if auth > 1 {
+		v.add("nats", "only one of user, token, credentialsFile and nkeySeedFile may be set")
+	}
+
+	if n.CredentialsFile != "" {
+		v.file("nats.credentialsFile", n.CredentialsFile)
+	}
+
+	if n.NKeySeedFile != "" {
+		v.file("nats.nkeySeedFile", n.NKeySeedFile)
+	}
+
+	if n.TLS.CAFile != "" {
+		v.file("nats.tls.caFile", n.TLS.CAFile)
+	}
+
+	if (n.TLS.CertFile == "") != (n.TLS.KeyFile == "") {
+		v.add("nats.tls", "certFile and keyFile must be set together")
+	} else if n.TLS.CertFile != "" {
+		v.file("nats.tls.certFile", n.TLS.CertFile)
+		v.file("nats.tls.keyFile", n.TLS.KeyFile)
+	}
+
+	if n.MaxReconnects < -1 {
+		v.add("nats.maxReconnects", "must be -1 (unlimited) or more")
+	}
+
+	v.positive("nats.connectTimeout", n.ConnectTimeout)
+	v.positive("nats.reconnectWait", n.ReconnectWait)
+	v.positive("nats.drainTimeout", n.DrainTimeout)
+
+	if n.ReconnectJitter.Duration < 0 {
+		v.add("nats.reconnectJitter", "must not be negative")
+	}
+}
+
+func (r Retry) validate(v *validator, prefix string) {
+	v.positive(prefix+".initialInterval", r.InitialInterval)
+	v.positive(prefix+".maxInterval", r.MaxInterval)
+	v.positive(prefix+".maxElapsedTime", r.MaxElapsedTime)
+
+	if r.Multiplier < 1 {
+		v.add(prefix+".multiplier", "must be at least 1")
+	}
+
+	if r.Jitter < 0 || r.Jitter > 1 {
+		v.add(prefix+".jitter", "must be between 0 and 1")
+	}
+}
+
+func (l Logging) validate(v *validator) {
+	v.oneOf("logging.level", l.Level, "debug", "info", "warn", "error")
+	v.oneOf("logging.encoding", l.Encoding, "json", "console")
+
+	if len(l.Outputs) == 0 {
+		v.add("logging.outputs", "is required")
+	}
+
+	if l.Rotation.MaxSizeMB < 0 {
+		v.add("logging.rotation.maxSizeMB", "must not be negative")
+	}
+
+	if l.Rotation.MaxAge.Duration < 0 {
+		v.add("logging.rotation.maxAge", "must not be negative")
+	}
+
+	if l.Rotation.MaxBackups < 0 {
+		v.add("logging.rotation.maxBackups", "must not be negative")
+	}
+
+	if l.Rotation.Retention.Duration < 0 {
+		v.add("logging.rotation.retention", "must not be negative")
+	}
+}
+
+func (p Purge) validate(v *validator) {
+	if p.Retention.Duration < 0 {
+		v.add("purge.retention", "must not be negative")
+	}
+
+	v.positive("purge.interval", p.Interval)
+
+	if p.BatchSize < 1 {
+		v.add("purge.batchSize", "must be at least 1")
+	}
+}
+
+func (r Reload) validate(v *validator) {
+	if r.Watch {
+		v.positive("reload.interval", r.Interval)
+	}
+}
+
+type validator struct {
+	problems []string
+}
+
+func (v *validator) add(key, problem string) {
+	v.problems = append(v.problems, key+": "+problem)
+}
+
+func (v *validator) err() error {
+	if len(v.problems) == 0 {
+		return nil
+	}
+
+	return &ValidationError{Problems: v.problems}
+}
+
+func (v *validator) required(key, value string) {
+	if strings.TrimSpace(value) == "" {
+		v.add(key, "is required")
+	}
+}
+
+func (v *validator) port(key, value string) {
+	if value == "" {
+		v.add(key, "is required")
+		return
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 || n > 65535 {
+		v.add(key, "must be a port number between 1 and 65535, got "+strconv.Quote(value))
+	}
+}
+
+func (v *validator) addr(key, value string) {
+	_, port, err := net.SplitHostPort(value)
+	if err != nil {
+		v.add(key, "must be host:port, got "+strconv.Quote(value))
+		return
+	}
+
+	v.port(key, port)
+}
+
+func (v *validator) url(key, value string, schemes ...string) {
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" {
+		v.add(key, "must be an absolute URL, got "+strconv.Quote(value))
+		return
+	}
+
+	v.oneOf(key, u.Scheme, schemes...)
+}
+
+func (v *validator) file(key, path string) {
+	_, err := os.Stat(path)
+	if err != nil {
+		v.add(key, err.Error())
+	}
+}
+
+func (v *validator) oneOf(key, value string, allowed ...string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+
+	v.add(key, "must be one of "+strings.Join(allowed, ", ")+", got "+strconv.Quote(value))
+}
+
+func (v *validator) positive(key string, d Duration) {
+	if d.Duration <= 0 {
+		v.add(key, "must be a positive duration, got "+strconv.Quote(d.String()))
+	}
+}
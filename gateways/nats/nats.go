@@ -1,11 +1,24 @@
 package nats
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"xm/configs"
+	"xm/pkg/health"
+	"xm/pkg/logger"
+	"xm/pkg/retry"
+
 	"github.com/nats-io/nats.go"
 	"go.uber.org/fx"
 )
 
-var Module = fx.Provide(New)
+var Module = fx.Options(
+	fx.Provide(New),
+	fx.Provide(fx.Annotated{Group: health.Group, Target: HealthCheck}),
+)
 
 type Gateway interface {
 	GetConnection() *nats.Conn
@@ -13,17 +26,160 @@ type Gateway interface {
 
 type gateway struct {
 	Connection *nats.Conn
+	closed     chan struct{}
+}
+
+type Params struct {
+	fx.In
+	Lifecycle fx.Lifecycle
+	Configs   configs.Configs
+	Logger    logger.Logger
 }
 
-func New() Gateway {
-	nc, err := nats.Connect("nats://nats-server:4222")
+func New(p Params) (Gateway, error) {
+	g := &gateway{
+		closed: make(chan struct{}),
+	}
+
+	cfg := p.Configs.Peek().NATS
+	log := p.Logger.Logger()
+
+	opts, err := options(cfg)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	return &gateway{
-		Connection: nc,
+	opts = append(opts,
+		nats.DisconnectErrHandler(func(nc *nats.Conn, err error) {
+			log.Warnw("nats disconnected", "error", err)
+		}),
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			log.Infow("nats reconnected", "url", nc.ConnectedUrl())
+		}),
+		nats.ErrorHandler(func(nc *nats.Conn, sub *nats.Subscription, err error) {
+			log.Errorw("nats error", "error", err)
+		}),
+		nats.ClosedHandler(func(nc *nats.Conn) {
+			log.Infow("nats connection closed")
+			close(g.closed)
+		}),
+	)
+
+	p.Lifecycle.Append(
+		fx.Hook{
+			OnStart: func(ctx context.Context) error {
+				var nc *nats.Conn
+
+				err := retry.Do(ctx, retry.FromConfig(p.Configs.Peek().StartupRetry),
+					func(attempt int, wait time.Duration, err error) {
+						log.Warnw("nats not ready, retrying", "attempt", attempt, "wait", wait, "error", err)
+					},
+					func(ctx context.Context) (err error) {
+						nc, err = nats.Connect(strings.Join(cfg.URLs, ","), opts...)
+						return
+					},
+				)
+				if err != nil {
+					return fmt.Errorf("nats: %w", err)
+				}
+
+				log.Infow("nats connected", "url", nc.ConnectedUrl())
+				g.Connection = nc
+
+				return nil
+			},
+			OnStop: func(ctx context.Context) error {
+				if g.Connection == nil {
+					return nil
+				}
+
+				err := g.Connection.Drain()
+				if err != nil {
+					return err
+				}
+
+				select {
+				case <-g.closed:
+					return nil
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			},
+		},
+	)
+
+	return g, nil
+}
+
+func HealthCheck(g Gateway) health.Check {
+	return health.Check{
+		Name: "nats",
+		Run: func(ctx context.Context) error {
+			nc := g.GetConnection()
+			if nc == nil {
+				return errors.New("not connected")
+			}
+
+			if status := nc.Status(); status != nats.CONNECTED {
+				return errors.New("connection status " + statusName(status))
+			}
+
+			return nil
+		},
+	}
+}
+
+func statusName(status nats.Status) string {
+	switch status {
+	case nats.DISCONNECTED:
+		return "disconnected"
+	case nats.RECONNECTING:
+		return "reconnecting"
+	case nats.CONNECTING:
+		return "connecting"
+	case nats.CLOSED:
+		return "closed"
+	case nats.DRAINING_SUBS, nats.DRAINING_PUBS:
+		return "draining"
+	default:
+		return "unknown"
+	}
+}
+
+func options(cfg configs.NATS) ([]nats.Option, error) {
+	opts := []nats.Option{
+		nats.Name(cfg.Name),
+		nats.Timeout(cfg.ConnectTimeout.Duration),
+		nats.MaxReconnects(cfg.MaxReconnects),
+		nats.ReconnectWait(cfg.ReconnectWait.Duration),
+		nats.ReconnectJitter(cfg.ReconnectJitter.Duration, cfg.ReconnectJitter.Duration),
+		nats.DrainTimeout(cfg.DrainTimeout.Duration),
 	}
+
+	switch {
+	case cfg.User != "":
+		opts = append(opts, nats.UserInfo(cfg.User, cfg.Password))
+	case cfg.Token != "":
+		opts = append(opts, nats.Token(cfg.Token))
+	case cfg.CredentialsFile != "":
+		opts = append(opts, nats.UserCredentials(cfg.CredentialsFile))
+	case cfg.NKeySeedFile != "":
+		opt, err := nats.NkeyOptionFromSeed(cfg.NKeySeedFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, opt)
+	}
+
+	if cfg.TLS.CAFile != "" {
+		opts = append(opts, nats.RootCAs(cfg.TLS.CAFile))
+	}
+
+	if cfg.TLS.CertFile != "" {
+		opts = append(opts, nats.ClientCert(cfg.TLS.CertFile, cfg.TLS.KeyFile))
+	}
+
+	return opts, nil
 }
 
 func (g *gateway) GetConnection() *nats.Conn {
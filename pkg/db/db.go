@@ -1,62 +1,196 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"time"
 	"xm/configs"
+	"xm/pkg/health"
+	"xm/pkg/logger"
+	"xm/pkg/metrics"
+	"xm/pkg/retry"
 
 	_ "github.com/lib/pq"
 	"go.uber.org/fx"
+	"go.uber.org/multierr"
 )
 
 var Module = fx.Options(
 	fx.Provide(New),
+	fx.Provide(NewTxManager),
+	fx.Provide(fx.Annotated{Group: health.Group, Target: HealthCheck}),
 )
 
 type Database interface {
 	Connection() *sql.DB
+	Reader(ctx context.Context) Executor
+	Executor(ctx context.Context) Executor
+	Query(ctx context.Context, name string) (context.Context, context.CancelFunc)
 	CloseConnection() error
+	Stats() sql.DBStats
 }
 
 type database struct {
-	db      *sql.DB
-	configs configs.Configs
+	db       *sql.DB
+	replicas []*replica
+	next     uint32
+	configs  configs.Configs
+	logger   logger.Logger
+	queries  *queries
 }
 
 type Params struct {
 	fx.In
-	Configs configs.Configs
+	Lifecycle fx.Lifecycle
+	Configs   configs.Configs
+	Logger    logger.Logger
 }
 
-func New(p Params) Database {
-	return &database{
-		db:      connect(p.Configs),
+func New(p Params) (Database, error) {
+	cfg := p.Configs.Peek().Database
+	log := p.Logger.Logger()
+
+	conn, err := open(cfg, cfg.Host, cfg.Port)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &database{
+		db:      conn,
 		configs: p.Configs,
+		logger:  p.Logger,
+		queries: newQueries(),
+	}
+
+	for _, r := range cfg.Replicas {
+		conn, err := open(cfg, r.Host, r.Port)
+		if err != nil {
+			_ = d.CloseConnection()
+			return nil, err
+		}
+
+		d.replicas = append(d.replicas, &replica{
+			name: r.Host + ":" + r.Port,
+			db:   conn,
+		})
+	}
+
+	metrics.Func("db", func() interface{} {
+		return d.Stats()
+	})
+	metrics.Func("db_replicas", func() interface{} {
+		return d.replicaStats()
+	})
+	metrics.Func("db_queries", func() interface{} {
+		return d.queries.snapshot()
+	})
+
+	done := make(chan struct{})
+
+	p.Lifecycle.Append(
+		fx.Hook{
+			OnStart: func(ctx context.Context) error {
+				err := retry.Do(ctx, retry.FromConfig(p.Configs.Peek().StartupRetry),
+					func(attempt int, wait time.Duration, err error) {
+						log.Warnw("postgres not ready, retrying", "attempt", attempt, "wait", wait, "error", err)
+					},
+					d.db.PingContext,
+				)
+				if err != nil {
+					return fmt.Errorf("postgres: %w", err)
+				}
+
+				log.Infow("postgres connected", "host", cfg.Host, "name", cfg.Name)
+
+				if len(d.replicas) > 0 {
+					d.checkReplicas(ctx, p.Logger, cfg.ReplicaCheckTimeout.Duration)
+					go d.watchReplicas(p.Logger, cfg.ReplicaCheckInterval.Duration, cfg.ReplicaCheckTimeout.Duration, done)
+				}
+
+				if cfg.StatsInterval.Duration > 0 {
+					go d.logStats(p.Logger, cfg.StatsInterval.Duration, done)
+				}
+				return nil
+			},
+			OnStop: func(ctx context.Context) error {
+				close(done)
+				return d.CloseConnection()
+			},
+		},
+	)
+
+	return d, nil
+}
+
+func HealthCheck(d Database) health.Check {
+	return health.Check{
+		Name: "postgres",
+		Run: func(ctx context.Context) error {
+			return d.Connection().PingContext(ctx)
+		},
 	}
 }
 
-func connect(cfg configs.Configs) *sql.DB {
-	psqlInfo := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		cfg.Peek().Database.Host, cfg.Peek().Database.Port, cfg.Peek().Database.User, cfg.Peek().Database.Password, cfg.Peek().Database.Name)
-	db, err := sql.Open("postgres", psqlInfo)
-	if err != nil {
-		panic(err)
+func open(cfg configs.Database, host, port string) (*sql.DB, error) {
+	psqlInfo := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+		host, port, cfg.User, cfg.Password, cfg.Name, cfg.SSLMode)
+	if cfg.Schema != "" {
+		psqlInfo += " search_path=" + cfg.Schema
 	}
 
-	err = db.Ping()
+	db, err := sql.Open("postgres", psqlInfo)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	fmt.Println("Successfully connected!")
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime.Duration)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime.Duration)
 
-	return db
+	return db, nil
 }
 
+// Connection returns the primary, which takes every write.
 func (d *database) Connection() *sql.DB {
 	return d.db
 }
 
 func (d *database) CloseConnection() error {
-	return d.db.Close()
+	err := d.db.Close()
+	for _, r := range d.replicas {
+		err = multierr.Append(err, r.db.Close())
+	}
+
+	return err
+}
+
+func (d *database) Stats() sql.DBStats {
+	return d.db.Stats()
+}
+
+func (d *database) logStats(l logger.Logger, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			s := d.Stats()
+			l.Logger().Infow("db pool",
+				"maxOpen", s.MaxOpenConnections,
+				"open", s.OpenConnections,
+				"inUse", s.InUse,
+				"idle", s.Idle,
+				"waitCount", s.WaitCount,
+				"waitDuration", s.WaitDuration,
+				"maxIdleClosed", s.MaxIdleClosed,
+				"maxIdleTimeClosed", s.MaxIdleTimeClosed,
+				"maxLifetimeClosed", s.MaxLifetimeClosed,
+			)
+		}
+	}
 }
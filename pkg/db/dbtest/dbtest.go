@@ -0,0 +1,152 @@
+// Package dbtest gives every test a postgres schema of its own, migrated
+// and dropped again when the test ends, so tests can run in parallel
+// without seeing each other's rows.
+package dbtest
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"testing"
+	"xm/configs"
+	"xm/pkg/db"
+	"xm/pkg/db/migrations"
+	"xm/pkg/logger"
+	"xm/pkg/repositories"
+	"xm/pkg/repositories/audit"
+	"xm/pkg/repositories/company"
+	"xm/pkg/repositories/user"
+	"xm/pkg/services"
+	userService "xm/pkg/services/user"
+
+	"go.uber.org/fx"
+	"go.uber.org/fx/fxtest"
+)
+
+// The test database is reached at XM_TEST_DATABASE_HOST, "testdb" by
+// default, and XM_TEST_DATABASE_NAME, also "testdb", with the password,
+// or a secret reference to it, in XM_TEST_DATABASE_PASSWORD; everything
+// else comes from the usual configuration. Tests are skipped when it is
+// down, unless XM_TEST_DATABASE_REQUIRED is set, as it is in CI.
+const (
+	envHost     = "XM_TEST_DATABASE_HOST"
+	envName     = "XM_TEST_DATABASE_NAME"
+	envPassword = "XM_TEST_DATABASE_PASSWORD"
+	envRequired = "XM_TEST_DATABASE_REQUIRED"
+)
+
+type Harness struct {
+	Configs   configs.Configs
+	DB        db.Database
+	TxManager db.TxManager
+
+	Companies company.Repository
+	Users     user.Repository
+	Audit     audit.Repository
+
+	UserService userService.Service
+}
+
+// New creates a schema for t, applies the migrations to it and builds the
+// repositories and services on top. Extra opts join the app, e.g. to
+// provide a nats.Gateway and populate the company service.
+func New(t testing.TB, opts ...fx.Option) *Harness {
+	t.Helper()
+
+	schema := "test_" + randomHex(t)
+
+	cfg, err := configs.Load([]string{
+		"--database.host", env(envHost, "testdb"),
+		"--database.name", env(envName, "testdb"),
+		"--database.password", os.Getenv(envPassword),
+		"--auth.jwtKey", "test-key",
+		"--database.schema", schema,
+		"--database.autoMigrate",
+		"--database.statsInterval", "0s",
+		"--startupRetry.maxElapsedTime", "1s",
+		"--logging.outputs", "stderr",
+		"--logging.level", "warn",
+	}, os.Environ())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	admin := connect(t, cfg.Peek().Database)
+
+	_, err = admin.Exec("CREATE SCHEMA " + schema)
+	if err != nil {
+		admin.Close()
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		defer admin.Close()
+
+		_, err := admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	var h Harness
+
+	app := fxtest.New(t,
+		fx.NopLogger,
+		fx.Provide(func() configs.Configs { return cfg }),
+		logger.Module,
+		db.Module,
+		migrations.Module,
+		repositories.Module,
+		services.Module,
+		fx.Options(opts...),
+		fx.Populate(&h.Configs, &h.DB, &h.TxManager, &h.Companies, &h.Users, &h.Audit, &h.UserService),
+	)
+
+	app.RequireStart()
+	t.Cleanup(app.RequireStop)
+
+	return &h
+}
+
+func connect(t testing.TB, cfg configs.Database) *sql.DB {
+	t.Helper()
+
+	conn, err := sql.Open("postgres", fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+		cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.Name, cfg.SSLMode))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = conn.Ping()
+	if err != nil {
+		conn.Close()
+
+		if os.Getenv(envRequired) == "" {
+			t.Skipf("test database unavailable: %v", err)
+		}
+		t.Fatalf("test database unavailable: %v", err)
+	}
+
+	return conn
+}
+
+func env(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+
+	return fallback
+}
+
+func randomHex(t testing.TB) string {
+	b := make([]byte, 6)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,232 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"xm/configs/secrets"
+
+	"github.com/lib/pq"
+)
+
+// Secret marks a query argument that must never be logged, such as a
+// password hash.
+type Secret string
+
+func (s Secret) Value() (driver.Value, error) {
+	return string(s), nil
+}
+
+type queryNameKey struct{}
+
+// Query prepares ctx for the named repository query, e.g.
+// "company.GetAll": it is bounded by database.queryTimeout, an earlier
+// deadline already on ctx still winning, and its statements are recorded
+// under name.
+func (d *database) Query(ctx context.Context, name string) (context.Context, context.CancelFunc) {
+	ctx = context.WithValue(ctx, queryNameKey{}, name)
+
+	timeout := d.configs.Peek().Database.QueryTimeout.Duration
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+func queryName(ctx context.Context) string {
+	if name, ok := ctx.Value(queryNameKey{}).(string); ok {
+		return name
+	}
+
+	return "unnamed"
+}
+
+// instrumented times every statement run through it, adds it to the
+// per-query metrics and logs it when it is slower than
+// database.slowQueryThreshold. Only ExecContext knows how many rows a
+// statement affected; QueryContext and QueryRowContext hand their rows to
+// the caller unread, so writes using RETURNING are recorded without rows.
+type instrumented struct {
+	Executor
+	d *database
+}
+
+func (i *instrumented) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	res, err := i.Executor.ExecContext(ctx, query, args...)
+
+	rows := int64(-1)
+	if err == nil {
+		rows, _ = res.RowsAffected()
+	}
+
+	i.d.observe(ctx, time.Since(start), rows, err, args)
+
+	return res, err
+}
+
+// QueryContext records the time until the first rows are available,
+// not the time spent reading them.
+func (i *instrumented) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := i.Executor.QueryContext(ctx, query, args...)
+
+	i.d.observe(ctx, time.Since(start), -1, err, args)
+
+	return rows, err
+}
+
+func (i *instrumented) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	start := time.Now()
+	row := i.Executor.QueryRowContext(ctx, query, args...)
+
+	i.d.observe(ctx, time.Since(start), -1, row.Err(), args)
+
+	return row
+}
+
+func (d *database) instrument(e Executor) Executor {
+	return &instrumented{Executor: e, d: d}
+}
+
+func (d *database) observe(ctx context.Context, took time.Duration, rows int64, err error, args []interface{}) {
+	name := queryName(ctx)
+	class := errorClass(ctx, err)
+
+	d.queries.add(name, took, rows, class)
+
+	threshold := d.configs.Peek().Database.SlowQueryThreshold.Duration
+	if threshold <= 0 || took < threshold {
+		return
+	}
+
+	d.queries.slow(name)
+
+	fields := []interface{}{"query", name, "duration", took, "args", redact(args)}
+	if rows >= 0 {
+		fields = append(fields, "rows", rows)
+	}
+	if err != nil {
+		fields = append(fields, "errorClass", class, "error", err)
+	}
+
+	d.logger.Logger().Warnw("slow query", fields...)
+}
+
+// errorClass buckets err for metrics: postgres errors by their SQLSTATE
+// class, e.g. "integrity_constraint_violation", and context errors as
+// "canceled" or "timeout".
+func errorClass(ctx context.Context, err error) string {
+	if err == nil {
+		return ""
+	}
+
+	var pqErr *pq.Error
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(ctx.Err(), context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return "timeout"
+	case errors.As(err, &pqErr):
+		return pqErr.Code.Class().Name()
+	default:
+		return "other"
+	}
+}
+
+const maxArgLen = 64
+
+func redact(args []interface{}) []string {
+	out := make([]string, len(args))
+	for i, arg := range args {
+		if _, ok := arg.(Secret); ok {
+			out[i] = secrets.Redacted
+			continue
+		}
+
+		s := fmt.Sprint(arg)
+		if len(s) > maxArgLen {
+			s = s[:maxArgLen] + "..."
+		}
+		out[i] = s
+	}
+
+	return out
+}
+
+// queryStats sums up the statements of one named query. Rows only counts
+// what Exec statements affected, see instrumented.
+type queryStats struct {
+	Count         int64
+	Slow          int64
+	Rows          int64
+	Errors        map[string]int64
+	TotalDuration time.Duration
+	MaxDuration   time.Duration
+}
+
+type queries struct {
+	mu    sync.Mutex
+	stats map[string]*queryStats
+}
+
+func newQueries() *queries {
+	return &queries{stats: make(map[string]*queryStats)}
+}
+
+func (q *queries) get(name string) *queryStats {
+	s, ok := q.stats[name]
+	if !ok {
+		s = &queryStats{Errors: make(map[string]int64)}
+		q.stats[name] = s
+	}
+
+	return s
+}
+
+func (q *queries) add(name string, took time.Duration, rows int64, class string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	s := q.get(name)
+	s.Count++
+	if rows > 0 {
+		s.Rows += rows
+	}
+	s.TotalDuration += took
+	if took > s.MaxDuration {
+		s.MaxDuration = took
+	}
+	if class != "" {
+		s.Errors[class]++
+	}
+}
+
+func (q *queries) slow(name string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.get(name).Slow++
+}
+
+func (q *queries) snapshot() map[string]queryStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	out := make(map[string]queryStats, len(q.stats))
+	for name, s := range q.stats {
+		c := *s
+		c.Errors = make(map[string]int64, len(s.Errors))
+		for class, n := range s.Errors {
+			c.Errors[class] = n
+		}
+		out[name] = c
+	}
+
+	return out
+}
@@ -0,0 +1,50 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"xm/configs/secrets"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedact(t *testing.T) {
+	args := redact([]interface{}{"alice", Secret("hash"), 42, strings.Repeat("x", 100)})
+
+	require.Equal(t, "alice", args[0])
+	require.Equal(t, secrets.Redacted, args[1])
+	require.Equal(t, "42", args[2])
+	require.Len(t, args[3], maxArgLen+len("..."))
+}
+
+func TestErrorClass(t *testing.T) {
+	ctx := context.Background()
+
+	require.Equal(t, "", errorClass(ctx, nil))
+	require.Equal(t, "integrity_constraint_violation", errorClass(ctx, &pq.Error{Code: "23505"}))
+	require.Equal(t, "timeout", errorClass(ctx, context.DeadlineExceeded))
+	require.Equal(t, "other", errorClass(ctx, errors.New("boom")))
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	require.Equal(t, "canceled", errorClass(canceled, &pq.Error{Code: "57014"}))
+}
+
+func TestQueries(t *testing.T) {
+	q := newQueries()
+	q.add("company.GetAll", 10*time.Millisecond, 3, "")
+	q.add("company.GetAll", 30*time.Millisecond, -1, "timeout")
+	q.slow("company.GetAll")
+
+	s := q.snapshot()["company.GetAll"]
+	require.Equal(t, int64(2), s.Count)
+	require.Equal(t, int64(1), s.Slow)
+	require.Equal(t, int64(3), s.Rows)
+	require.Equal(t, 40*time.Millisecond, s.TotalDuration)
+	require.Equal(t, 30*time.Millisecond, s.MaxDuration)
+	require.Equal(t, map[string]int64{"timeout": 1}, s.Errors)
+}
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS companies;
+
+DROP TABLE IF EXISTS users;
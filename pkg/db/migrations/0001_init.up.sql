@@ -1,4 +1,4 @@
-CREATE TABLE users (
+CREATE TABLE IF NOT EXISTS users (
     id serial primary key,
     username varchar(100) unique,
     password varchar(100),
@@ -6,7 +6,7 @@ CREATE TABLE users (
     updated_at timestamp default now()
 );
 
-CREATE TABLE companies(
+CREATE TABLE IF NOT EXISTS companies(
     id serial primary key,
     name varchar(100) not null,
     code varchar(50) not null,
@@ -16,4 +16,4 @@ CREATE TABLE companies(
     status varchar(20) not null default 'active',
     created_at timestamp not null default now(),
     updated_at timestamp not null default now()
-);
\ No newline at end of file
+);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS companies_code_key;
@@ -0,0 +1 @@
+CREATE UNIQUE INDEX IF NOT EXISTS companies_code_key ON companies (code) WHERE status <> 'deleted';
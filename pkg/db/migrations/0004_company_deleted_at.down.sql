@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS companies_deleted_at_idx;
+ALTER TABLE companies DROP COLUMN IF EXISTS deleted_at;
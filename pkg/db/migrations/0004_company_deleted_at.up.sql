@@ -0,0 +1,6 @@
+ALTER TABLE companies ADD COLUMN IF NOT EXISTS deleted_at timestamp;
+
+-- Rows deleted before the column existed count from their last update.
+UPDATE companies SET deleted_at = updated_at WHERE status = 'deleted' AND deleted_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS companies_deleted_at_idx ON companies (deleted_at) WHERE status = 'deleted';
@@ -0,0 +1,13 @@
+-- No foreign key to companies: the history outlives a purge.
+CREATE TABLE IF NOT EXISTS company_audit (
+    id bigserial primary key,
+    company_id integer not null,
+    actor_id integer,
+    action varchar(20) not null,
+    before jsonb,
+    after jsonb,
+    request_id varchar(100) not null default '',
+    created_at timestamp not null default now()
+);
+
+CREATE INDEX IF NOT EXISTS company_audit_company_id_idx ON company_audit (company_id, id);
@@ -0,0 +1,334 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+	"xm/configs"
+	"xm/pkg/db"
+	"xm/pkg/logger"
+
+	"go.uber.org/fx"
+)
+
+var Module = fx.Options(
+	fx.Provide(New),
+	fx.Invoke(AutoMigrate),
+)
+
+//go:embed *.sql
+var files embed.FS
+
+// lockID is the postgres advisory lock that keeps concurrent
+// instances from migrating the same database at once.
+const lockID = 4242001
+
+type Migrator interface {
+	Up(ctx context.Context) error
+	Down(ctx context.Context) error
+	To(ctx context.Context, version int) error
+	Status(ctx context.Context) ([]Status, error)
+}
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+type migrator struct {
+	db         *sql.DB
+	logger     logger.Logger
+	migrations []Migration
+}
+
+type Params struct {
+	fx.In
+	DB     db.Database
+	Logger logger.Logger
+}
+
+func New(p Params) (Migrator, error) {
+	migrations, err := List()
+	if err != nil {
+		return nil, err
+	}
+
+	return &migrator{
+		db:         p.DB.Connection(),
+		logger:     p.Logger,
+		migrations: migrations,
+	}, nil
+}
+
+// AutoMigrate brings the schema up to date on start when
+// database.autoMigrate is set, which is meant for dev and test setups.
+func AutoMigrate(lc fx.Lifecycle, cfg configs.Configs, m Migrator) {
+	if !cfg.Peek().Database.AutoMigrate {
+		return
+	}
+
+	lc.Append(
+		fx.Hook{
+			OnStart: m.Up,
+		},
+	)
+}
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// List returns the embedded migrations ordered by version.
+func List() ([]Migration, error) {
+	entries, err := fs.ReadDir(files, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, e := range entries {
+		parts := fileName.FindStringSubmatch(e.Name())
+		if parts == nil {
+			return nil, fmt.Errorf("migrations: bad file name %s", e.Name())
+		}
+
+		version, _ := strconv.Atoi(parts[1])
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: parts[2]}
+			byVersion[version] = m
+		}
+
+		if m.Name != parts[2] {
+			return nil, fmt.Errorf("migrations: version %d is used by %s and %s", version, m.Name, parts[2])
+		}
+
+		data, err := fs.ReadFile(files, e.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		if parts[3] == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migrations: %04d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+func (m *migrator) Up(ctx context.Context) error {
+	if len(m.migrations) == 0 {
+		return nil
+	}
+
+	return m.To(ctx, m.migrations[len(m.migrations)-1].Version)
+}
+
+// Down rolls back the most recently applied migration.
+func (m *migrator) Down(ctx context.Context) error {
+	return m.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			if _, ok := applied[m.migrations[i].Version]; ok {
+				return m.down(ctx, conn, m.migrations[i])
+			}
+		}
+
+		return nil
+	})
+}
+
+// To applies or rolls back migrations until exactly those up to
+// version are applied.
+func (m *migrator) To(ctx context.Context, version int) error {
+	if version != 0 && m.find(version) == nil {
+		return fmt.Errorf("migrations: unknown version %d", version)
+	}
+
+	return m.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			mig := m.migrations[i]
+			if _, ok := applied[mig.Version]; ok && mig.Version > version {
+				err = m.down(ctx, conn, mig)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, mig := range m.migrations {
+			if _, ok := applied[mig.Version]; !ok && mig.Version <= version {
+				err = m.up(ctx, conn, mig)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+func (m *migrator) Status(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, mig := range m.migrations {
+			at, ok := applied[mig.Version]
+			statuses = append(statuses, Status{
+				Migration: mig,
+				Applied:   ok,
+				AppliedAt: at,
+			})
+		}
+
+		return nil
+	})
+
+	return statuses, err
+}
+
+func (m *migrator) find(version int) *Migration {
+	for i := range m.migrations {
+		if m.migrations[i].Version == version {
+			return &m.migrations[i]
+		}
+	}
+
+	return nil
+}
+
+func (m *migrator) up(ctx context.Context, conn *sql.Conn, mig Migration) error {
+	m.logger.Logger().Infow("applying migration", "version", mig.Version, "name", mig.Name)
+
+	return inTx(ctx, conn, mig.Up, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, mig.Version, mig.Name)
+}
+
+func (m *migrator) down(ctx context.Context, conn *sql.Conn, mig Migration) error {
+	m.logger.Logger().Infow("rolling back migration", "version", mig.Version, "name", mig.Name)
+
+	return inTx(ctx, conn, mig.Down, `DELETE FROM schema_migrations WHERE version = $1`, mig.Version)
+}
+
+func inTx(ctx context.Context, conn *sql.Conn, script, bookkeeping string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, script)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, bookkeeping, args...)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// locked runs fn on a single connection holding the migrations
+// advisory lock, creating schema_migrations if needed.
+func (m *migrator) locked(ctx context.Context, fn func(conn *sql.Conn) error) (err error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockID)
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		_, unlockErr := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockID)
+		if err == nil {
+			err = unlockErr
+		}
+	}()
+
+	query := `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version int primary key,
+			name varchar(100) not null,
+			applied_at timestamp not null default now()
+		)
+	`
+
+	_, err = conn.ExecContext(ctx, query)
+	if err != nil {
+		return
+	}
+
+	return fn(conn)
+}
+
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+
+		err = rows.Scan(&version, &at)
+		if err != nil {
+			return nil, err
+		}
+
+		applied[version] = at
+	}
+
+	return applied, rows.Err()
+}
@@ -0,0 +1,20 @@
+package migrations_test
+
+import (
+	"testing"
+	"xm/pkg/db/migrations"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestList(t *testing.T) {
+	list, err := migrations.List()
+	require.NoError(t, err)
+	require.NotEmpty(t, list)
+
+	for i, m := range list {
+		require.Equal(t, i+1, m.Version, "migration versions must be contiguous")
+		require.NotEmpty(t, m.Up)
+		require.NotEmpty(t, m.Down)
+	}
+}
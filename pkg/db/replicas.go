@@ -0,0 +1,109 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"sync/atomic"
+	"time"
+	"xm/pkg/logger"
+)
+
+type replica struct {
+	name    string
+	db      *sql.DB
+	healthy int32
+}
+
+type primaryKey struct{}
+
+// WithPrimary makes reads done with the returned context go to the
+// primary, e.g. right after a write they must see.
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+func usePrimary(ctx context.Context) bool {
+	v, _ := ctx.Value(primaryKey{}).(bool)
+	return v
+}
+
+// Reader returns a healthy read replica, taking turns between them,
+// or the primary when there is none or ctx asks for it. Inside a
+// transaction it returns the transaction so reads see its writes.
+func (d *database) Reader(ctx context.Context) Executor {
+	return d.instrument(d.reader(ctx))
+}
+
+func (d *database) reader(ctx context.Context) Executor {
+	if s := txFrom(ctx); s != nil {
+		return s.tx
+	}
+
+	if len(d.replicas) == 0 || usePrimary(ctx) {
+		return d.db
+	}
+
+	start := int(atomic.AddUint32(&d.next, 1))
+	for i := 0; i < len(d.replicas); i++ {
+		r := d.replicas[(start+i)%len(d.replicas)]
+		if atomic.LoadInt32(&r.healthy) == 1 {
+			return r.db
+		}
+	}
+
+	return d.db
+}
+
+func (d *database) watchReplicas(l logger.Logger, interval, timeout time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			d.checkReplicas(context.Background(), l, timeout)
+		}
+	}
+}
+
+func (d *database) checkReplicas(ctx context.Context, l logger.Logger, timeout time.Duration) {
+	for _, r := range d.replicas {
+		pingCtx, cancel := context.WithTimeout(ctx, timeout)
+		err := r.db.PingContext(pingCtx)
+		cancel()
+
+		var healthy int32
+		if err == nil {
+			healthy = 1
+		}
+
+		if atomic.SwapInt32(&r.healthy, healthy) == healthy {
+			continue
+		}
+
+		if err != nil {
+			l.Logger().Warnw("replica down, reading from primary", "replica", r.name, "error", err)
+		} else {
+			l.Logger().Infow("replica up", "replica", r.name)
+		}
+	}
+}
+
+type replicaStats struct {
+	Healthy bool
+	sql.DBStats
+}
+
+func (d *database) replicaStats() map[string]replicaStats {
+	stats := make(map[string]replicaStats, len(d.replicas))
+	for _, r := range d.replicas {
+		stats[r.name] = replicaStats{
+			Healthy: atomic.LoadInt32(&r.healthy) == 1,
+			DBStats: r.db.Stats(),
+		}
+	}
+
+	return stats
+}
@@ -0,0 +1,38 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReader(t *testing.T) {
+	open := func() *sql.DB {
+		db, err := sql.Open("postgres", "host=localhost")
+		require.NoError(t, err)
+		return db
+	}
+
+	d := &database{db: open()}
+	require.Same(t, d.db, d.reader(context.Background()))
+
+	a := &replica{name: "a", db: open(), healthy: 1}
+	b := &replica{name: "b", db: open(), healthy: 1}
+	d.replicas = []*replica{a, b}
+
+	first, second := d.reader(context.Background()), d.reader(context.Background())
+	require.NotSame(t, first, second)
+	require.NotSame(t, d.db, first)
+	require.NotSame(t, d.db, second)
+
+	require.Same(t, d.db, d.reader(WithPrimary(context.Background())))
+
+	a.healthy = 0
+	require.Same(t, b.db, d.reader(context.Background()))
+	require.Same(t, b.db, d.reader(context.Background()))
+
+	b.healthy = 0
+	require.Same(t, d.db, d.reader(context.Background()))
+}
@@ -0,0 +1,173 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"xm/configs"
+	"xm/pkg/logger"
+	"xm/pkg/retry"
+
+	"github.com/lib/pq"
+	"go.uber.org/multierr"
+)
+
+// Executor is what *sql.DB and *sql.Tx have in common, so repositories
+// can run the same statements inside or outside a transaction.
+type Executor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// TxManager runs units of work that span several repositories.
+type TxManager interface {
+	// Do runs fn in a transaction that is committed if fn returns nil
+	// and rolled back otherwise. Repositories called with the context
+	// passed to fn take part in the transaction. A Do nested in another
+	// runs in a savepoint, so its failure only undoes its own writes.
+	// The outermost Do runs fn again on serialization failures and
+	// deadlocks, so fn must not have side effects outside the database.
+	Do(ctx context.Context, opts *sql.TxOptions, fn func(ctx context.Context) error) error
+}
+
+type txManager struct {
+	db      Database
+	configs configs.Configs
+	logger  logger.Logger
+}
+
+func NewTxManager(d Database, c configs.Configs, l logger.Logger) TxManager {
+	return &txManager{
+		db:      d,
+		configs: c,
+		logger:  l,
+	}
+}
+
+type nopTxManager struct{}
+
+// NopTxManager returns a TxManager that simply calls fn, for storage
+// without transactions such as the in-memory repositories.
+func NopTxManager() TxManager {
+	return nopTxManager{}
+}
+
+func (nopTxManager) Do(ctx context.Context, opts *sql.TxOptions, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+type txKey struct{}
+
+type txState struct {
+	tx    *sql.Tx
+	depth int
+}
+
+func txFrom(ctx context.Context) *txState {
+	s, _ := ctx.Value(txKey{}).(*txState)
+	return s
+}
+
+// Executor returns the transaction ctx carries, or the primary.
+func (d *database) Executor(ctx context.Context) Executor {
+	if s := txFrom(ctx); s != nil {
+		return d.instrument(s.tx)
+	}
+
+	return d.instrument(d.db)
+}
+
+func (m *txManager) Do(ctx context.Context, opts *sql.TxOptions, fn func(ctx context.Context) error) error {
+	if s := txFrom(ctx); s != nil {
+		return savepoint(ctx, s, fn)
+	}
+
+	policy := retry.FromConfig(m.configs.Peek().Database.TxRetry)
+
+	return retry.Do(ctx, policy,
+		func(attempt int, wait time.Duration, err error) {
+			m.logger.Logger().Warnw("transaction conflict, retrying", "attempt", attempt, "wait", wait, "error", err)
+		},
+		func(ctx context.Context) error {
+			err := m.run(ctx, opts, fn)
+			if err != nil && !retryable(err) {
+				return retry.Permanent(err)
+			}
+
+			return err
+		},
+	)
+}
+
+func (m *txManager) run(ctx context.Context, opts *sql.TxOptions, fn func(ctx context.Context) error) error {
+	tx, err := m.db.Connection().BeginTx(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	// A panicking fn must not leave the connection in a transaction.
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	err = fn(context.WithValue(ctx, txKey{}, &txState{tx: tx}))
+	if err != nil {
+		return multierr.Append(err, ignoreDone(tx.Rollback()))
+	}
+
+	return tx.Commit()
+}
+
+func savepoint(ctx context.Context, s *txState, fn func(ctx context.Context) error) error {
+	nested := &txState{tx: s.tx, depth: s.depth + 1}
+	name := fmt.Sprintf("sp_%d", nested.depth)
+
+	_, err := s.tx.ExecContext(ctx, "SAVEPOINT "+name)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_, _ = s.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name)
+			panic(p)
+		}
+	}()
+
+	err = fn(context.WithValue(ctx, txKey{}, nested))
+	if err != nil {
+		_, rerr := s.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name)
+		return multierr.Append(err, rerr)
+	}
+
+	_, err = s.tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name)
+
+	return err
+}
+
+// retryable reports whether err is a serialization failure or a deadlock,
+// after which the whole transaction can simply be run again.
+func retryable(err error) bool {
+	for _, e := range multierr.Errors(err) {
+		var pqErr *pq.Error
+		if errors.As(e, &pqErr) && (pqErr.Code == "40001" || pqErr.Code == "40P01") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func ignoreDone(err error) error {
+	if errors.Is(err, sql.ErrTxDone) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,35 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/multierr"
+)
+
+func TestExecutor(t *testing.T) {
+	conn, err := sql.Open("postgres", "host=localhost")
+	require.NoError(t, err)
+
+	d := &database{db: conn, replicas: []*replica{{name: "a", db: conn, healthy: 1}}}
+	require.Same(t, conn, d.Executor(context.Background()).(*instrumented).Executor)
+
+	tx := &sql.Tx{}
+	ctx := context.WithValue(context.Background(), txKey{}, &txState{tx: tx})
+	require.Same(t, tx, d.Executor(ctx).(*instrumented).Executor)
+	require.Same(t, tx, d.reader(ctx))
+}
+
+func TestRetryable(t *testing.T) {
+	require.True(t, retryable(&pq.Error{Code: "40001"}))
+	require.True(t, retryable(fmt.Errorf("update: %w", &pq.Error{Code: "40P01"})))
+	require.True(t, retryable(multierr.Append(&pq.Error{Code: "40001"}, errors.New("rollback failed"))))
+
+	require.False(t, retryable(&pq.Error{Code: "23505"}))
+	require.False(t, retryable(sql.ErrNoRows))
+}
@@ -0,0 +1,150 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"xm/pkg/db"
+	"xm/pkg/db/dbtest"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDo(t *testing.T) {
+	h := newHarness(t)
+	ctx := context.Background()
+
+	err := h.TxManager.Do(ctx, nil, func(ctx context.Context) error {
+		return insert(ctx, h.DB, 1)
+	})
+	require.NoError(t, err)
+
+	errFailed := errors.New("failed")
+	err = h.TxManager.Do(ctx, nil, func(ctx context.Context) error {
+		err := insert(ctx, h.DB, 2)
+		if err != nil {
+			return err
+		}
+
+		return errFailed
+	})
+	require.ErrorIs(t, err, errFailed)
+
+	require.Equal(t, []int{1}, ids(t, h.DB))
+}
+
+func TestDoSavepoint(t *testing.T) {
+	h := newHarness(t)
+	ctx := context.Background()
+
+	errFailed := errors.New("failed")
+	err := h.TxManager.Do(ctx, nil, func(ctx context.Context) error {
+		err := insert(ctx, h.DB, 1)
+		if err != nil {
+			return err
+		}
+
+		err = h.TxManager.Do(ctx, nil, func(ctx context.Context) error {
+			err := insert(ctx, h.DB, 2)
+			if err != nil {
+				return err
+			}
+
+			return errFailed
+		})
+		require.ErrorIs(t, err, errFailed)
+
+		return h.TxManager.Do(ctx, nil, func(ctx context.Context) error {
+			return insert(ctx, h.DB, 3)
+		})
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, []int{1, 3}, ids(t, h.DB))
+}
+
+func TestDoPanic(t *testing.T) {
+	h := newHarness(t)
+	ctx := context.Background()
+
+	require.PanicsWithValue(t, "boom", func() {
+		_ = h.TxManager.Do(ctx, nil, func(ctx context.Context) error {
+			err := insert(ctx, h.DB, 1)
+			if err != nil {
+				return err
+			}
+
+			panic("boom")
+		})
+	})
+
+	require.Empty(t, ids(t, h.DB))
+	require.Zero(t, h.DB.Connection().Stats().InUse)
+}
+
+func TestDoRetry(t *testing.T) {
+	h := newHarness(t)
+	ctx := context.Background()
+
+	for _, code := range []pq.ErrorCode{"40001", "40P01"} {
+		var attempts int
+		err := h.TxManager.Do(ctx, nil, func(ctx context.Context) error {
+			attempts++
+
+			err := insert(ctx, h.DB, attempts)
+			if err != nil || attempts > 1 {
+				return err
+			}
+
+			return &pq.Error{Code: code}
+		})
+		require.NoError(t, err)
+		require.Equal(t, 2, attempts)
+
+		require.Equal(t, []int{2}, ids(t, h.DB))
+
+		_, err = h.DB.Connection().Exec("DELETE FROM tx_test")
+		require.NoError(t, err)
+	}
+
+	errConflict := &pq.Error{Code: "23505"}
+	var attempts int
+	err := h.TxManager.Do(ctx, nil, func(ctx context.Context) error {
+		attempts++
+		return errConflict
+	})
+	require.ErrorIs(t, err, errConflict)
+	require.Equal(t, 1, attempts)
+}
+
+func newHarness(t *testing.T) *dbtest.Harness {
+	t.Parallel()
+
+	h := dbtest.New(t)
+
+	_, err := h.DB.Connection().Exec("CREATE TABLE tx_test (id int PRIMARY KEY)")
+	require.NoError(t, err)
+
+	return h
+}
+
+func insert(ctx context.Context, d db.Database, id int) error {
+	_, err := d.Executor(ctx).ExecContext(ctx, "INSERT INTO tx_test (id) VALUES ($1)", id)
+	return err
+}
+
+func ids(t *testing.T, d db.Database) (ids []int) {
+	rows, err := d.Connection().Query("SELECT id FROM tx_test ORDER BY id")
+	require.NoError(t, err)
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		require.NoError(t, rows.Scan(&id))
+		ids = append(ids, id)
+	}
+	require.NoError(t, rows.Err())
+
+	return
+}
@@ -1,10 +1,16 @@
 package handlers
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
+	"io"
+	"mime"
 	"net/http"
 	"strconv"
+	"strings"
+	"xm/pkg/db"
+	"xm/pkg/patch"
 	"xm/pkg/repositories/company"
 	"xm/pkg/services/utils"
 )
@@ -26,10 +32,14 @@ func (h *handlers) CreateCompany(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = h.companyService.Create(c)
+	err = h.companyService.Create(r.Context(), c)
+	if errors.Is(err, utils.ErrAlreadyExists) {
+		apiResp.Set(http.StatusConflict, http.StatusText(http.StatusConflict), err.Error())
+		return
+	}
+
 	if err != nil {
-		apiResp.Set(http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError), nil)
-		h.logger.Logger().Error(err)
+		h.serverError(&apiResp, err)
 		return
 	}
 
@@ -47,18 +57,20 @@ func (h *handlers) GetCompanyByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	c, err := h.companyService.GetByID(id)
+	// The ETag is what If-Match is checked against on the next write, so
+	// it must not come from a lagging replica.
+	c, err := h.companyService.GetByID(db.WithPrimary(r.Context()), id)
 	if err != nil {
 		if err == utils.ErrNotFound {
 			apiResp.Set(http.StatusNotFound, http.StatusText(http.StatusNotFound), nil)
 			return
 		}
 
-		apiResp.Set(http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError), nil)
-		h.logger.Logger().Error(err)
+		h.serverError(&apiResp, err)
 		return
 	}
 
+	w.Header().Set("ETag", etag(c.Version))
 	apiResp.Set(http.StatusOK, http.StatusText(http.StatusOK), c)
 }
 
@@ -73,15 +85,26 @@ func (h *handlers) GetAllCompanies(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	c, err := h.companyService.GetAll(f)
+	switch f.Status {
+	case "", company.StatusActive:
+	case company.StatusDeleted, company.StatusAll:
+		if !h.isAdmin(r) {
+			apiResp.Set(http.StatusForbidden, http.StatusText(http.StatusForbidden), "only admins may list deleted companies")
+			return
+		}
+	default:
+		apiResp.Set(http.StatusBadRequest, http.StatusText(http.StatusBadRequest), "bad status")
+		return
+	}
+
+	c, err := h.companyService.GetAll(r.Context(), f)
 	if err != nil {
 		if err == utils.ErrNotFound {
 			apiResp.Set(http.StatusNotFound, http.StatusText(http.StatusNotFound), nil)
 			return
 		}
 
-		apiResp.Set(http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError), nil)
-		h.logger.Logger().Error(err)
+		h.serverError(&apiResp, err)
 		return
 	}
 
@@ -99,14 +122,212 @@ func (h *handlers) UpdateCompany(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = h.companyService.Update(c)
+	var ok bool
+	c.Version, ok = ifMatch(r)
+	if !ok {
+		apiResp.Set(http.StatusPreconditionFailed, http.StatusText(http.StatusPreconditionFailed), nil)
+		return
+	}
+
+	updated, err := h.companyService.Update(r.Context(), c)
+	if err != nil {
+		h.writeError(&apiResp, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(updated.Version))
+	apiResp.Set(http.StatusOK, http.StatusText(http.StatusOK), updated)
+}
+
+// ReplaceCompany overwrites every editable field of the company with the
+// body, which must pass validate like a new company. Read-only members
+// such as id and version are ignored.
+func (h *handlers) ReplaceCompany(w http.ResponseWriter, r *http.Request) {
+	var apiResp ApiResp
+	defer apiResp.Respond(w)
+
+	idS := r.URL.Query().Get("id")
+	id, err := strconv.Atoi(idS)
+	if err != nil {
+		apiResp.Set(http.StatusBadRequest, http.StatusText(http.StatusBadRequest), "bad id")
+		return
+	}
+
+	var f companyFields
+	err = json.NewDecoder(r.Body).Decode(&f)
+	if err != nil {
+		apiResp.Set(http.StatusBadRequest, http.StatusText(http.StatusBadRequest), err.Error())
+		return
+	}
+
+	version, ok := ifMatch(r)
+	if !ok {
+		apiResp.Set(http.StatusPreconditionFailed, http.StatusText(http.StatusPreconditionFailed), nil)
+		return
+	}
+
+	c := f.company(id, version)
+
+	err = validate(c)
+	if err != nil {
+		apiResp.Set(http.StatusBadRequest, http.StatusText(http.StatusBadRequest), err.Error())
+		return
+	}
+
+	updated, err := h.companyService.Replace(r.Context(), c)
 	if err != nil {
-		apiResp.Set(http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError), nil)
-		h.logger.Logger().Error(err)
+		h.writeError(&apiResp, err)
 		return
 	}
 
-	apiResp.Set(http.StatusOK, http.StatusText(http.StatusOK), "updated")
+	w.Header().Set("ETag", etag(updated.Version))
+	apiResp.Set(http.StatusOK, http.StatusText(http.StatusOK), updated)
+}
+
+// companyFields are the parts of a company clients can write; patches
+// apply to them and any other member makes the result invalid.
+type companyFields struct {
+	Name    string `json:"name"`
+	Code    string `json:"code"`
+	Country string `json:"country"`
+	Website string `json:"website"`
+	Phone   string `json:"phone"`
+}
+
+func fieldsOf(c company.Company) companyFields {
+	return companyFields{
+		Name:    c.Name,
+		Code:    c.Code,
+		Country: c.Country,
+		Website: c.Website,
+		Phone:   c.Phone,
+	}
+}
+
+func (f companyFields) company(id, version int) company.Company {
+	return company.Company{
+		ID:      id,
+		Name:    f.Name,
+		Code:    f.Code,
+		Country: f.Country,
+		Website: f.Website,
+		Phone:   f.Phone,
+		Version: version,
+	}
+}
+
+// PatchCompany applies a JSON Merge Patch or a JSON Patch, chosen by the
+// Content-Type, to the company's editable fields and stores the result
+// if it passes validate. Unlike UpdateCompany, fields can be cleared.
+func (h *handlers) PatchCompany(w http.ResponseWriter, r *http.Request) {
+	var apiResp ApiResp
+	defer apiResp.Respond(w)
+
+	idS := r.URL.Query().Get("id")
+	id, err := strconv.Atoi(idS)
+	if err != nil {
+		apiResp.Set(http.StatusBadRequest, http.StatusText(http.StatusBadRequest), "bad id")
+		return
+	}
+
+	var apply func(doc, p []byte) ([]byte, error)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case patch.MergePatchType:
+		apply = patch.Merge
+	case patch.JSONPatchType:
+		apply = patch.Apply
+	default:
+		w.Header().Set("Accept-Patch", patch.MergePatchType+", "+patch.JSONPatchType)
+		apiResp.Set(http.StatusUnsupportedMediaType, http.StatusText(http.StatusUnsupportedMediaType), nil)
+		return
+	}
+
+	version, ok := ifMatch(r)
+	if !ok {
+		apiResp.Set(http.StatusPreconditionFailed, http.StatusText(http.StatusPreconditionFailed), nil)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		apiResp.Set(http.StatusBadRequest, http.StatusText(http.StatusBadRequest), err.Error())
+		return
+	}
+
+	updated, err := h.companyService.Patch(r.Context(), id, version, func(current company.Company) (company.Company, error) {
+		doc, _ := json.Marshal(fieldsOf(current))
+
+		doc, err := apply(doc, body)
+		if err != nil {
+			switch {
+			case errors.Is(err, patch.ErrInvalid):
+				return company.Company{}, &rejectedPatch{status: http.StatusBadRequest, err: err}
+			case errors.Is(err, patch.ErrTestFailed):
+				return company.Company{}, &rejectedPatch{status: http.StatusConflict, err: err}
+			default:
+				return company.Company{}, &rejectedPatch{status: http.StatusUnprocessableEntity, err: err}
+			}
+		}
+
+		var f companyFields
+		dec := json.NewDecoder(bytes.NewReader(doc))
+		dec.DisallowUnknownFields()
+
+		err = dec.Decode(&f)
+		if err != nil {
+			return company.Company{}, &rejectedPatch{status: http.StatusUnprocessableEntity, err: err}
+		}
+
+		c := f.company(id, current.Version)
+
+		err = validate(c)
+		if err != nil {
+			return company.Company{}, &rejectedPatch{status: http.StatusUnprocessableEntity, err: err}
+		}
+
+		return c, nil
+	})
+
+	var rejected *rejectedPatch
+	if errors.As(err, &rejected) {
+		apiResp.Set(rejected.status, http.StatusText(rejected.status), rejected.err.Error())
+		return
+	}
+
+	if err != nil {
+		h.writeError(&apiResp, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(updated.Version))
+	apiResp.Set(http.StatusOK, http.StatusText(http.StatusOK), updated)
+}
+
+// rejectedPatch is how PatchCompany turns down a patch from inside the
+// service call, with the status to answer.
+type rejectedPatch struct {
+	status int
+	err    error
+}
+
+func (e *rejectedPatch) Error() string {
+	return e.err.Error()
+}
+
+// writeError answers the errors of the company write endpoints.
+func (h *handlers) writeError(apiResp *ApiResp, err error) {
+	switch {
+	case err == utils.ErrNotFound:
+		apiResp.Set(http.StatusNotFound, http.StatusText(http.StatusNotFound), nil)
+	case errors.Is(err, utils.ErrAlreadyExists):
+		apiResp.Set(http.StatusConflict, http.StatusText(http.StatusConflict), err.Error())
+	case err == utils.ErrPreconditionFailed:
+		apiResp.Set(http.StatusPreconditionFailed, http.StatusText(http.StatusPreconditionFailed), nil)
+	default:
+		h.serverError(apiResp, err)
+	}
 }
 
 func (h *handlers) DeleteCompany(w http.ResponseWriter, r *http.Request) {
@@ -120,21 +341,138 @@ func (h *handlers) DeleteCompany(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = h.companyService.DeleteByID(id)
+	version, ok := ifMatch(r)
+	if !ok {
+		apiResp.Set(http.StatusPreconditionFailed, http.StatusText(http.StatusPreconditionFailed), nil)
+		return
+	}
+
+	err = h.companyService.DeleteByID(r.Context(), id, version)
 	if err != nil {
 		if err == utils.ErrNotFound {
 			apiResp.Set(http.StatusNotFound, http.StatusText(http.StatusNotFound), nil)
 			return
 		}
 
-		apiResp.Set(http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError), nil)
-		h.logger.Logger().Error(err)
+		if err == utils.ErrPreconditionFailed {
+			apiResp.Set(http.StatusPreconditionFailed, http.StatusText(http.StatusPreconditionFailed), nil)
+			return
+		}
+
+		h.serverError(&apiResp, err)
 		return
 	}
 
 	apiResp.Set(http.StatusOK, http.StatusText(http.StatusOK), "deleted")
 }
 
+// RestoreCompany brings a deleted company back. It is served behind
+// Admin.
+func (h *handlers) RestoreCompany(w http.ResponseWriter, r *http.Request) {
+	var apiResp ApiResp
+	defer apiResp.Respond(w)
+
+	idS := r.URL.Query().Get("id")
+	id, err := strconv.Atoi(idS)
+	if err != nil {
+		apiResp.Set(http.StatusBadRequest, http.StatusText(http.StatusBadRequest), "bad id")
+		return
+	}
+
+	version, ok := ifMatch(r)
+	if !ok {
+		apiResp.Set(http.StatusPreconditionFailed, http.StatusText(http.StatusPreconditionFailed), nil)
+		return
+	}
+
+	restored, err := h.companyService.Restore(r.Context(), id, version)
+	if err != nil {
+		h.writeError(&apiResp, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(restored.Version))
+	apiResp.Set(http.StatusOK, http.StatusText(http.StatusOK), restored)
+}
+
+const (
+	defaultHistoryLimit = 20
+	maxHistoryLimit     = 100
+)
+
+// GetCompanyHistory pages through the audit trail of a company, deleted
+// or purged ones included. Like GetCompanyByID it is open to any signed
+// in user. limit defaults to 20, at most 100.
+func (h *handlers) GetCompanyHistory(w http.ResponseWriter, r *http.Request) {
+	var apiResp ApiResp
+	defer apiResp.Respond(w)
+
+	q := r.URL.Query()
+
+	id, err := strconv.Atoi(q.Get("id"))
+	if err != nil {
+		apiResp.Set(http.StatusBadRequest, http.StatusText(http.StatusBadRequest), "bad id")
+		return
+	}
+
+	limit := defaultHistoryLimit
+	if v := q.Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxHistoryLimit {
+			apiResp.Set(http.StatusBadRequest, http.StatusText(http.StatusBadRequest), "bad limit")
+			return
+		}
+	}
+
+	var offset int
+	if v := q.Get("offset"); v != "" {
+		offset, err = strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			apiResp.Set(http.StatusBadRequest, http.StatusText(http.StatusBadRequest), "bad offset")
+			return
+		}
+	}
+
+	entries, err := h.companyService.History(r.Context(), id, limit, offset)
+	if err != nil {
+		if err == utils.ErrNotFound {
+			apiResp.Set(http.StatusNotFound, http.StatusText(http.StatusNotFound), nil)
+			return
+		}
+
+		h.serverError(&apiResp, err)
+		return
+	}
+
+	apiResp.Set(http.StatusOK, http.StatusText(http.StatusOK), entries)
+}
+
+// etag is the strong entity tag of a company at version.
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// ifMatch returns the version an If-Match header asks for, or zero when
+// there is no header or it is "*". ok is false for anything that cannot
+// match a company, such as weak or malformed tags.
+func ifMatch(r *http.Request) (version int, ok bool) {
+	v := strings.TrimSpace(r.Header.Get("If-Match"))
+	if v == "" || v == "*" {
+		return 0, true
+	}
+
+	if len(v) < 3 || v[0] != '"' || v[len(v)-1] != '"' {
+		return 0, false
+	}
+
+	version, err := strconv.Atoi(v[1 : len(v)-1])
+	if err != nil || version < 1 {
+		return 0, false
+	}
+
+	return version, true
+}
+
 func validate(c company.Company) error {
 	if c.Name == "" {
 		return errors.New("no name provided")
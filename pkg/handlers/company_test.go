@@ -2,23 +2,28 @@ package handlers_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 	"xm/configs"
-	"xm/pkg/db"
 	"xm/pkg/handlers"
 	"xm/pkg/logger"
 	"xm/pkg/repositories"
+	"xm/pkg/repositories/audit"
 	"xm/pkg/repositories/company"
+	userRepository "xm/pkg/repositories/user"
 	companyService "xm/pkg/services/company"
 	"xm/pkg/services/user"
 	"xm/pkg/services/utils"
 
+	"github.com/dgrijalva/jwt-go"
 	"github.com/stretchr/testify/mock"
 	"go.uber.org/fx"
 	"go.uber.org/fx/fxtest"
@@ -31,6 +36,7 @@ func TestCreateCompany(t *testing.T) {
 		name     string
 		c        company.Company
 		status   int
+		err      error
 		expected string
 	}{
 		{
@@ -45,6 +51,19 @@ func TestCreateCompany(t *testing.T) {
 			status:   200,
 			expected: `{"code":200,"message":"OK","payload":"created"}`,
 		},
+		{
+			name: "code taken",
+			c: company.Company{
+				Name:    "name",
+				Code:    "taken",
+				Country: "country",
+				Website: "website",
+				Phone:   "phone",
+			},
+			status:   409,
+			err:      &utils.ConflictError{Field: "code"},
+			expected: `{"code":409,"message":"Conflict","payload":"code already exists"}`,
+		},
 		{
 			name: "no name",
 			c: company.Company{
@@ -104,7 +123,7 @@ func TestCreateCompany(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			m.On("Create", tt.c).Return(nil)
+			m.On("Create", tt.c).Return(tt.err)
 
 			cJson, _ := json.Marshal(tt.c)
 
@@ -196,12 +215,16 @@ func TestGetCompanyByID(t *testing.T) {
 }
 
 func TestGetAllCompanies(t *testing.T) {
+	t.Setenv("XM_AUTH_JWT_KEY", testJWTKey)
+	t.Setenv("XM_AUTH_ADMINS", "admin")
+
 	h, m := getTestHandlerCompany(t)
 
 	tests := []struct {
 		name    string
 		c       []company.Company
 		filters string
+		user    string
 		status  int
 		err     error
 	}{
@@ -233,6 +256,30 @@ func TestGetAllCompanies(t *testing.T) {
 			filters: `{"limit":13}`,
 			err:     errors.New("some error"),
 		},
+		{
+			name:    "deleted as admin",
+			c:       []company.Company{{Status: "deleted"}},
+			status:  200,
+			filters: `{"limit":14,"status":"deleted"}`,
+			user:    "admin",
+		},
+		{
+			name:    "all as user",
+			status:  403,
+			filters: `{"limit":15,"status":"all"}`,
+			user:    "user",
+		},
+		{
+			name:    "deleted without token",
+			status:  403,
+			filters: `{"limit":16,"status":"deleted"}`,
+		},
+		{
+			name:    "bad status",
+			status:  400,
+			filters: `{"limit":17,"status":"archived"}`,
+			user:    "admin",
+		},
 	}
 
 	for _, tt := range tests {
@@ -243,6 +290,9 @@ func TestGetAllCompanies(t *testing.T) {
 			m.On("GetAll", filters).Return(tt.c, tt.err).Once()
 
 			req := httptest.NewRequest("POST", "/companies", strings.NewReader(tt.filters))
+			if tt.user != "" {
+				req.Header.Set("token", testToken(t, tt.user))
+			}
 
 			rr := httptest.NewRecorder()
 			handler := http.HandlerFunc(h.GetAllCompanies)
@@ -265,6 +315,8 @@ func TestDeleteCompany(t *testing.T) {
 		name    string
 		queryID string
 		id      int
+		ifMatch string
+		version int
 		status  int
 		err     error
 	}{
@@ -294,13 +346,32 @@ func TestDeleteCompany(t *testing.T) {
 			status:  500,
 			err:     errors.New("some error"),
 		},
+		{
+			id:      4,
+			queryID: "?id=4",
+			ifMatch: `"7"`,
+			version: 7,
+			name:    "stale version",
+			status:  412,
+			err:     utils.ErrPreconditionFailed,
+		},
+		{
+			id:      5,
+			queryID: "?id=5",
+			ifMatch: `W/"7"`,
+			name:    "weak etag",
+			status:  412,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			m.On("DeleteByID", tt.id).Return(tt.err)
+			m.On("DeleteByID", tt.id, tt.version).Return(tt.err)
 
 			req := httptest.NewRequest("DELETE", "/company"+tt.queryID, nil)
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
 
 			rr := httptest.NewRecorder()
 			handler := http.HandlerFunc(h.DeleteCompany)
@@ -320,16 +391,35 @@ func TestUpdateCompany(t *testing.T) {
 	h, m := getTestHandlerCompany(t)
 
 	tests := []struct {
-		name   string
-		cmp    string
-		status int
-		err    error
+		name    string
+		cmp     string
+		ifMatch string
+		updated company.Company
+		status  int
+		etag    string
+		err     error
 	}{
 		{
-			name:   "ok",
-			status: 200,
-			cmp:    `{"name":"some name"}`,
-			err:    nil,
+			name:    "ok",
+			status:  200,
+			cmp:     `{"name":"some name"}`,
+			ifMatch: `"3"`,
+			updated: company.Company{ID: 1, Name: "some name", Version: 4},
+			etag:    `"4"`,
+			err:     nil,
+		},
+		{
+			name:    "stale version",
+			status:  412,
+			cmp:     `{"name":"stale"}`,
+			ifMatch: `"2"`,
+			err:     utils.ErrPreconditionFailed,
+		},
+		{
+			name:   "not found",
+			status: 404,
+			cmp:    `{"id":99,"name":"missing"}`,
+			err:    utils.ErrNotFound,
 		},
 		{
 			name:   "bad requst",
@@ -349,10 +439,14 @@ func TestUpdateCompany(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			var c company.Company
 			json.NewDecoder(strings.NewReader(tt.cmp)).Decode(&c)
+			if tt.ifMatch != "" {
+				c.Version, _ = strconv.Atoi(strings.Trim(tt.ifMatch, `"`))
+			}
 
-			m.On("Update", c).Return(tt.err).Once()
+			m.On("Update", c).Return(tt.updated, tt.err).Once()
 
 			req := httptest.NewRequest("PATCH", "/company", strings.NewReader(tt.cmp))
+			req.Header.Set("If-Match", tt.ifMatch)
 
 			rr := httptest.NewRecorder()
 			handler := http.HandlerFunc(h.UpdateCompany)
@@ -364,22 +458,455 @@ func TestUpdateCompany(t *testing.T) {
 				t.Errorf("handler returned wrong status code: got %v want %v",
 					status, tt.status)
 			}
+
+			if etag := rr.Header().Get("ETag"); etag != tt.etag {
+				t.Errorf("handler returned wrong etag: got %v want %v", etag, tt.etag)
+			}
 		})
 	}
 }
 
+func TestReplaceCompany(t *testing.T) {
+	h, m := getTestHandlerCompany(t)
+
+	full := company.Company{ID: 1, Name: "name", Code: "code", Country: "country", Website: "website", Phone: "phone"}
+
+	tests := []struct {
+		name    string
+		queryID string
+		cmp     string
+		ifMatch string
+		call    company.Company
+		updated company.Company
+		status  int
+		err     error
+	}{
+		{
+			name:    "ok",
+			queryID: "?id=1",
+			cmp:     `{"name":"name","code":"code","country":"country","website":"website","phone":"phone"}`,
+			ifMatch: `"3"`,
+			call:    company.Company{ID: 1, Name: "name", Code: "code", Country: "country", Website: "website", Phone: "phone", Version: 3},
+			updated: company.Company{ID: 1, Name: "name", Version: 4},
+			status:  200,
+		},
+		{
+			name:    "read-only fields ignored",
+			queryID: "?id=1",
+			cmp:     `{"id":7,"status":"deleted","name":"name","code":"code","country":"country","website":"website","phone":"phone"}`,
+			call:    full,
+			updated: company.Company{ID: 1, Name: "name", Version: 2},
+			status:  200,
+		},
+		{
+			name:    "missing field",
+			queryID: "?id=1",
+			cmp:     `{"name":"name","code":"code","country":"country","website":"website"}`,
+			status:  400,
+		},
+		{
+			name:    "bad id",
+			queryID: "?id=a",
+			status:  400,
+		},
+		{
+			name:    "stale version",
+			queryID: "?id=1",
+			cmp:     `{"name":"name","code":"code","country":"country","website":"website","phone":"phone"}`,
+			ifMatch: `"2"`,
+			call:    company.Company{ID: 1, Name: "name", Code: "code", Country: "country", Website: "website", Phone: "phone", Version: 2},
+			status:  412,
+			err:     utils.ErrPreconditionFailed,
+		},
+		{
+			name:    "not found",
+			queryID: "?id=2",
+			cmp:     `{"name":"name","code":"code","country":"country","website":"website","phone":"phone"}`,
+			call:    company.Company{ID: 2, Name: "name", Code: "code", Country: "country", Website: "website", Phone: "phone"},
+			status:  404,
+			err:     utils.ErrNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m.On("Replace", tt.call).Return(tt.updated, tt.err).Once()
+
+			req := httptest.NewRequest("PUT", "/company"+tt.queryID, strings.NewReader(tt.cmp))
+			req.Header.Set("If-Match", tt.ifMatch)
+
+			rr := httptest.NewRecorder()
+			handler := http.HandlerFunc(h.ReplaceCompany)
+
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.status {
+				t.Errorf("handler returned wrong status code: got %v want %v",
+					status, tt.status)
+			}
+		})
+	}
+}
+
+func TestPatchCompany(t *testing.T) {
+	h, m := getTestHandlerCompany(t)
+
+	current := company.Company{ID: 5, Name: "name", Code: "code", Country: "country", Website: "website", Phone: "phone", Version: 3}
+	m.On("Patch", 5, 0).Return(current, nil)
+	m.On("Patch", 5, 3).Return(current, nil)
+	m.On("Patch", 5, 2).Return(company.Company{}, utils.ErrPreconditionFailed)
+	m.On("Patch", 6, 0).Return(company.Company{}, utils.ErrNotFound)
+	m.On("Patch", 7, 0).Return(company.Company{}, &utils.ConflictError{Field: "code"})
+
+	tests := []struct {
+		name        string
+		queryID     string
+		contentType string
+		patch       string
+		ifMatch     string
+		status      int
+		etag        string
+	}{
+		{
+			name:        "merge patch",
+			queryID:     "?id=5",
+			contentType: "application/merge-patch+json",
+			patch:       `{"name":"renamed"}`,
+			status:      200,
+			etag:        `"4"`,
+		},
+		{
+			name:        "json patch",
+			queryID:     "?id=5",
+			contentType: "application/json-patch+json; charset=utf-8",
+			patch:       `[{"op":"test","path":"/name","value":"name"},{"op":"replace","path":"/name","value":"renamed"}]`,
+			ifMatch:     `"3"`,
+			status:      200,
+			etag:        `"4"`,
+		},
+		{
+			name:        "cleared field fails validation",
+			queryID:     "?id=5",
+			contentType: "application/merge-patch+json",
+			patch:       `{"phone":null}`,
+			status:      422,
+		},
+		{
+			name:        "read-only field",
+			queryID:     "?id=5",
+			contentType: "application/json-patch+json",
+			patch:       `[{"op":"add","path":"/version","value":9}]`,
+			status:      422,
+		},
+		{
+			name:        "wrong type",
+			queryID:     "?id=5",
+			contentType: "application/merge-patch+json",
+			patch:       `{"name":5}`,
+			status:      422,
+		},
+		{
+			name:        "test fails",
+			queryID:     "?id=5",
+			contentType: "application/json-patch+json",
+			patch:       `[{"op":"test","path":"/name","value":"other"}]`,
+			status:      409,
+		},
+		{
+			name:        "malformed patch",
+			queryID:     "?id=5",
+			contentType: "application/json-patch+json",
+			patch:       `{"op":"remove"}`,
+			status:      400,
+		},
+		{
+			name:        "unsupported media type",
+			queryID:     "?id=5",
+			contentType: "application/json",
+			patch:       `{"name":"renamed"}`,
+			status:      415,
+		},
+		{
+			name:        "stale if-match",
+			queryID:     "?id=5",
+			contentType: "application/merge-patch+json",
+			patch:       `{"name":"renamed"}`,
+			ifMatch:     `"2"`,
+			status:      412,
+		},
+		{
+			name:        "code taken",
+			queryID:     "?id=7",
+			contentType: "application/merge-patch+json",
+			patch:       `{"name":"renamed"}`,
+			status:      409,
+		},
+		{
+			name:        "not found",
+			queryID:     "?id=6",
+			contentType: "application/merge-patch+json",
+			patch:       `{"name":"renamed"}`,
+			status:      404,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("PATCH", "/company"+tt.queryID, strings.NewReader(tt.patch))
+			req.Header.Set("Content-Type", tt.contentType)
+			req.Header.Set("If-Match", tt.ifMatch)
+
+			rr := httptest.NewRecorder()
+			handler := http.HandlerFunc(h.PatchCompany)
+
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.status {
+				t.Errorf("handler returned wrong status code: got %v want %v: %s",
+					status, tt.status, rr.Body)
+			}
+
+			if etag := rr.Header().Get("ETag"); etag != tt.etag {
+				t.Errorf("handler returned wrong etag: got %v want %v", etag, tt.etag)
+			}
+
+			if tt.status == 200 && !strings.Contains(rr.Body.String(), `"name":"renamed"`) {
+				t.Errorf("handler returned wrong body: %s", rr.Body)
+			}
+		})
+	}
+
+	m.AssertExpectations(t)
+}
+
+func TestRestoreCompany(t *testing.T) {
+	t.Setenv("XM_AUTH_JWT_KEY", testJWTKey)
+	t.Setenv("XM_AUTH_ADMINS", "admin")
+
+	h, m := getTestHandlerCompany(t)
+
+	tests := []struct {
+		name     string
+		queryID  string
+		ifMatch  string
+		user     string
+		restored company.Company
+		status   int
+		etag     string
+		err      error
+	}{
+		{
+			name:     "ok",
+			queryID:  "?id=1",
+			user:     "admin",
+			restored: company.Company{ID: 1, Status: "active", Version: 3},
+			status:   200,
+			etag:     `"3"`,
+		},
+		{
+			name:    "not admin",
+			queryID: "?id=1",
+			user:    "user",
+			status:  403,
+		},
+		{
+			name:    "code taken",
+			queryID: "?id=2",
+			user:    "admin",
+			status:  409,
+			err:     &utils.ConflictError{Field: "code"},
+		},
+		{
+			name:    "stale version",
+			queryID: "?id=3",
+			ifMatch: `"1"`,
+			user:    "admin",
+			status:  412,
+			err:     utils.ErrPreconditionFailed,
+		},
+		{
+			name:    "not deleted",
+			queryID: "?id=4",
+			user:    "admin",
+			status:  404,
+			err:     utils.ErrNotFound,
+		},
+		{
+			name:    "bad id",
+			queryID: "?id=a",
+			user:    "admin",
+			status:  400,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, _ := strconv.Atoi(strings.TrimPrefix(tt.queryID, "?id="))
+			version, _ := strconv.Atoi(strings.Trim(tt.ifMatch, `"`))
+			m.On("Restore", id, version).Return(tt.restored, tt.err).Once()
+
+			req := httptest.NewRequest("POST", "/company/restore"+tt.queryID, nil)
+			req.Header.Set("token", testToken(t, tt.user))
+			req.Header.Set("If-Match", tt.ifMatch)
+
+			rr := httptest.NewRecorder()
+			handler := h.Admin(http.HandlerFunc(h.RestoreCompany))
+
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.status {
+				t.Errorf("handler returned wrong status code: got %v want %v",
+					status, tt.status)
+			}
+
+			if etag := rr.Header().Get("ETag"); etag != tt.etag {
+				t.Errorf("handler returned wrong etag: got %v want %v", etag, tt.etag)
+			}
+		})
+	}
+}
+
+func TestGetCompanyHistory(t *testing.T) {
+	t.Setenv("XM_AUTH_JWT_KEY", testJWTKey)
+	t.Setenv("XM_AUTH_ADMINS", "admin")
+
+	h, m := getTestHandlerCompany(t)
+
+	m.On("History", 1, 20, 0).Return([]audit.Entry{{CompanyID: 1, Action: audit.ActionCreate}}, nil)
+	m.On("History", 1, 5, 10).Return(nil, utils.ErrNotFound)
+
+	tests := []struct {
+		name   string
+		query  string
+		user   string
+		status int
+	}{
+		{name: "ok", query: "?id=1", user: "admin", status: 200},
+		{name: "paged past the end", query: "?id=1&limit=5&offset=10", user: "admin", status: 404},
+		{name: "not admin", query: "?id=1", user: "user", status: 200},
+		{name: "signed out", query: "?id=1", status: 400},
+		{name: "bad id", query: "?id=a", user: "admin", status: 400},
+		{name: "limit too large", query: "?id=1&limit=101", user: "admin", status: 400},
+		{name: "negative offset", query: "?id=1&offset=-1", user: "admin", status: 400},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/company/history"+tt.query, nil)
+			if tt.user != "" {
+				req.Header.Set("token", testToken(t, tt.user))
+			}
+
+			rr := httptest.NewRecorder()
+			handler := h.Middleware(http.HandlerFunc(h.GetCompanyHistory))
+
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.status {
+				t.Errorf("handler returned wrong status code: got %v want %v",
+					status, tt.status)
+			}
+		})
+	}
+}
+
+func TestAuditContext(t *testing.T) {
+	t.Setenv("XM_AUTH_JWT_KEY", testJWTKey)
+
+	h, _ := getTestHandlerCompany(t)
+
+	var actor int
+	var requestID string
+	handler := h.LogRequest(h.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor = utils.Actor(r.Context())
+		requestID = utils.RequestID(r.Context())
+	})))
+
+	req := httptest.NewRequest("GET", "/company?id=1", nil)
+	req.Header.Set("token", testTokenFor(t, userRepository.User{ID: 7, Username: "user"}))
+	req.Header.Set("X-Request-ID", "req1")
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if actor != 7 || requestID != "req1" || rr.Header().Get("X-Request-ID") != "req1" {
+		t.Errorf("got actor %d, request id %q, header %q", actor, requestID, rr.Header().Get("X-Request-ID"))
+	}
+
+	req = httptest.NewRequest("GET", "/company?id=1", nil)
+	req.Header.Set("token", testToken(t, "user"))
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if requestID == "" || requestID == "req1" || rr.Header().Get("X-Request-ID") != requestID {
+		t.Errorf("got request id %q, header %q", requestID, rr.Header().Get("X-Request-ID"))
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	t.Setenv("XM_AUTH_JWT_KEY", testJWTKey)
+	t.Setenv("XM_AUTH_ADMINS", "admin")
+
+	h, _ := getTestHandlerCompany(t)
+
+	handler := h.Middleware(h.Admin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+
+	for username, code := range map[string]int{"admin": http.StatusOK, "user": http.StatusForbidden} {
+		req := httptest.NewRequest("GET", "/admin/metrics", nil)
+		req.Header.Set("token", testToken(t, username))
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != code {
+			t.Errorf("%s: got status %d, want %d", username, rr.Code, code)
+		}
+	}
+}
+
+const testJWTKey = "test-key"
+
+func testToken(t *testing.T, username string) string {
+	return testTokenFor(t, userRepository.User{Username: username})
+}
+
+func testTokenFor(t *testing.T, u userRepository.User) string {
+	claims := &handlers.Claims{
+		User: u,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		},
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(testJWTKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
 func getTestHandlerCompany(t *testing.T) (handlers.Handlers, *companyMocker) {
+	t.Setenv("XM_DATABASE_PASSWORD", "")
+	t.Setenv("XM_AUTH_JWT_KEY", testJWTKey)
+	t.Setenv("XM_LOGGING_OUTPUTS", "stderr")
+	t.Setenv("XM_STORAGE_DRIVER", "memory")
+
 	var h handlers.Handlers
 	m := &companyMocker{}
 
-	go fxtest.New(
-		fxtest.TB(t),
+	// The app is only built, not started, so nothing connects to
+	// postgres or NATS and no lifecycle hook outlives the test.
+	fxtest.New(
+		t,
 		fx.Options(
 			configs.Module,
 			logger.Module,
 			handlers.Module,
-			repositories.Module,
-			db.Module,
+			repositories.Memory,
 
 			user.Module,
 			fx.Provide(
@@ -389,7 +916,7 @@ func getTestHandlerCompany(t *testing.T) (handlers.Handlers, *companyMocker) {
 			),
 		),
 		fx.Populate(&h),
-	).Run()
+	)
 
 	return h, m
 }
@@ -398,29 +925,69 @@ type companyMocker struct {
 	mock.Mock
 }
 
-func (m *companyMocker) Create(c company.Company) (err error) {
+func (m *companyMocker) Create(_ context.Context, c company.Company) (err error) {
 	args := m.Called(c)
 	return args.Error(0)
 }
 
-func (m *companyMocker) GetByID(id int) (c company.Company, err error) {
+func (m *companyMocker) GetByID(_ context.Context, id int) (c company.Company, err error) {
 	args := m.Called(id)
 	return args.Get(0).(company.Company), args.Error(1)
 }
 
-func (m *companyMocker) GetAll(f company.Filters) (companies []company.Company, err error) {
+func (m *companyMocker) GetAll(_ context.Context, f company.Filters) (companies []company.Company, err error) {
 	args := m.Called(f)
 	companies, _ = args.Get(0).([]company.Company)
 
 	return companies, args.Error(1)
 }
 
-func (m *companyMocker) Update(c company.Company) (err error) {
+func (m *companyMocker) Update(_ context.Context, c company.Company) (updated company.Company, err error) {
 	args := m.Called(c)
-	return args.Error(0)
+	return args.Get(0).(company.Company), args.Error(1)
 }
 
-func (m *companyMocker) DeleteByID(id int) (err error) {
-	args := m.Called(id)
+func (m *companyMocker) Replace(_ context.Context, c company.Company) (updated company.Company, err error) {
+	args := m.Called(c)
+	return args.Get(0).(company.Company), args.Error(1)
+}
+
+// Patch runs fn on the company stubbed for id and version and returns its
+// result as stored, one version on.
+func (m *companyMocker) Patch(_ context.Context, id, version int, fn func(current company.Company) (company.Company, error)) (updated company.Company, err error) {
+	args := m.Called(id, version)
+	if err = args.Error(1); err != nil {
+		return company.Company{}, err
+	}
+
+	updated, err = fn(args.Get(0).(company.Company))
+	if err != nil {
+		return company.Company{}, err
+	}
+
+	updated.Version++
+
+	return updated, nil
+}
+
+func (m *companyMocker) DeleteByID(_ context.Context, id, version int) (err error) {
+	args := m.Called(id, version)
 	return args.Error(0)
 }
+
+func (m *companyMocker) Restore(_ context.Context, id, version int) (restored company.Company, err error) {
+	args := m.Called(id, version)
+	return args.Get(0).(company.Company), args.Error(1)
+}
+
+func (m *companyMocker) Purge(_ context.Context, olderThan time.Duration, batchSize int, dryRun bool) (n int, err error) {
+	args := m.Called(olderThan, batchSize, dryRun)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *companyMocker) History(_ context.Context, id, limit, offset int) (entries []audit.Entry, err error) {
+	args := m.Called(id, limit, offset)
+	entries, _ = args.Get(0).([]audit.Entry)
+
+	return entries, args.Error(1)
+}
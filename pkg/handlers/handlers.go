@@ -1,11 +1,15 @@
 package handlers
 
 import (
+	"xm/configs"
+	"xm/pkg/db"
 	"xm/pkg/logger"
 	userRepository "xm/pkg/repositories/user"
 	"xm/pkg/services/company"
 	userService "xm/pkg/services/user"
 
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"net/http"
 	"time"
@@ -22,19 +26,25 @@ type Handlers interface {
 	SignUp(w http.ResponseWriter, r *http.Request)
 	SignIn(w http.ResponseWriter, r *http.Request)
 	Middleware(next http.Handler) http.Handler
+	Admin(next http.Handler) http.Handler
 	LogRequest(next http.Handler) http.Handler
 
 	CreateCompany(w http.ResponseWriter, r *http.Request)
 	GetCompanyByID(w http.ResponseWriter, r *http.Request)
 	GetAllCompanies(w http.ResponseWriter, r *http.Request)
 	UpdateCompany(w http.ResponseWriter, r *http.Request)
+	ReplaceCompany(w http.ResponseWriter, r *http.Request)
+	PatchCompany(w http.ResponseWriter, r *http.Request)
 	DeleteCompany(w http.ResponseWriter, r *http.Request)
+	RestoreCompany(w http.ResponseWriter, r *http.Request)
+	GetCompanyHistory(w http.ResponseWriter, r *http.Request)
 }
 
 type handlers struct {
 	userService    userService.Service
 	companyService company.Service
 	logger         logger.Logger
+	configs        configs.Configs
 }
 
 type Params struct {
@@ -42,6 +52,7 @@ type Params struct {
 	UserService    userService.Service
 	CompanyService company.Service
 	Logger         logger.Logger
+	Configs        configs.Configs
 }
 
 func New(p Params) Handlers {
@@ -49,11 +60,10 @@ func New(p Params) Handlers {
 		userService:    p.UserService,
 		companyService: p.CompanyService,
 		logger:         p.Logger,
+		configs:        p.Configs,
 	}
 }
 
-var jwtKey = []byte("secret_key")
-
 type Claims struct {
 	userRepository.User
 	jwt.StandardClaims
@@ -80,6 +90,13 @@ func (h *handlers) SignUp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Admins are only made with xm user create, or anyone could claim an
+	// admin name nobody has registered yet. They look taken to callers.
+	if h.isAdminName(credentials.Username) {
+		apiResp.Set(http.StatusBadRequest, http.StatusText(http.StatusBadRequest), "already registered")
+		return
+	}
+
 	hashedPassword, err := HashPassword(credentials.Password)
 	if err != nil {
 		apiResp.Set(http.StatusBadRequest, http.StatusText(http.StatusBadRequest), err.Error())
@@ -91,15 +108,14 @@ func (h *handlers) SignUp(w http.ResponseWriter, r *http.Request) {
 		Password: hashedPassword,
 	}
 
-	err = h.userService.Create(user)
+	err = h.userService.Create(r.Context(), user)
 	if err == utils.ErrAlreadyExists {
 		apiResp.Set(http.StatusBadRequest, http.StatusText(http.StatusBadRequest), "already registered")
 		return
 	}
 
 	if err != nil {
-		apiResp.Set(http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError), nil)
-		h.logger.Logger().Error(err)
+		h.serverError(&apiResp, err)
 		return
 	}
 
@@ -122,15 +138,15 @@ func (h *handlers) SignIn(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := h.userService.GetByUsername(credentials.Username)
+	// Read the primary so a user can sign in right after signing up.
+	user, err := h.userService.GetByUsername(db.WithPrimary(r.Context()), credentials.Username)
 	if err != nil {
 		if err == utils.ErrNotFound {
 			apiResp.Set(http.StatusBadRequest, http.StatusText(http.StatusBadRequest), "incorrect username or password")
 			return
 		}
 
-		apiResp.Set(http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError), nil)
-		h.logger.Logger().Error(err)
+		h.serverError(&apiResp, err)
 		return
 	}
 
@@ -149,7 +165,7 @@ func (h *handlers) SignIn(w http.ResponseWriter, r *http.Request) {
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtKey)
+	tokenString, err := token.SignedString(h.jwtKey())
 
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -171,7 +187,7 @@ func (h *handlers) Middleware(next http.Handler) http.Handler {
 
 		tkn, err := jwt.ParseWithClaims(tokenStr, claims,
 			func(t *jwt.Token) (interface{}, error) {
-				return jwtKey, nil
+				return h.jwtKey(), nil
 			})
 
 		if err != nil {
@@ -192,19 +208,56 @@ func (h *handlers) Middleware(next http.Handler) http.Handler {
 			return
 		}
 
+		next.ServeHTTP(w, r.WithContext(utils.WithActor(r.Context(), claims.ID)))
+	})
+}
+
+// Admin lets only auth.admins through to next. It goes inside
+// Middleware, which checks the token.
+func (h *handlers) Admin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !h.isAdmin(r) {
+			var apiResp ApiResp
+			apiResp.Set(http.StatusForbidden, http.StatusText(http.StatusForbidden), nil)
+			apiResp.Respond(w)
+			return
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
 
+// LogRequest logs how long each request took. It also gives the request
+// an id, the caller's X-Request-ID if it sent a usable one, and echoes it
+// back so changes in the audit trail can be traced to requests.
 func (h *handlers) LogRequest(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		defer h.logger.Logger().Infof("time taken %v", time.Since(start))
 
-		next.ServeHTTP(w, r)
+		id := r.Header.Get("X-Request-ID")
+		if id == "" || len(id) > maxRequestIDLen {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+
+		defer func() {
+			h.logger.Logger().Infof("time taken %v, request %s", time.Since(start), id)
+		}()
+
+		next.ServeHTTP(w, r.WithContext(utils.WithRequestID(r.Context(), id)))
 	})
 }
 
+// maxRequestIDLen is the size of company_audit.request_id.
+const maxRequestIDLen = 100
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
 func HashPassword(password string) (string, error) {
 	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
 	return string(bytes), err
@@ -215,14 +268,18 @@ func CheckPasswordHash(password, hash string) bool {
 	return err == nil
 }
 
-func GetClaims(r *http.Request) (*Claims, error) {
+func (h *handlers) jwtKey() []byte {
+	return []byte(h.configs.Peek().Auth.JWTKey)
+}
+
+func (h *handlers) getClaims(r *http.Request) (*Claims, error) {
 	claims := &Claims{}
 
 	tokenStr := r.Header.Get("token")
 
 	_, err := jwt.ParseWithClaims(tokenStr, claims,
 		func(t *jwt.Token) (interface{}, error) {
-			return jwtKey, nil
+			return h.jwtKey(), nil
 		})
 	if err != nil {
 		return nil, err
@@ -231,6 +288,47 @@ func GetClaims(r *http.Request) (*Claims, error) {
 	return claims, nil
 }
 
+// isAdmin reports whether the request carries the token of one of
+// auth.admins.
+func (h *handlers) isAdmin(r *http.Request) bool {
+	claims, err := h.getClaims(r)
+	if err != nil {
+		return false
+	}
+
+	return h.isAdminName(claims.Username)
+}
+
+func (h *handlers) isAdminName(username string) bool {
+	for _, admin := range h.configs.Peek().Auth.Admins {
+		if username == admin {
+			return true
+		}
+	}
+
+	return false
+}
+
+// StatusClientClosedRequest is the nginx convention for a request the
+// client gave up on before the response was ready.
+const StatusClientClosedRequest = 499
+
+// serverError reports an unexpected service error. Requests that were
+// canceled by the client or ran past the query timeout are told apart
+// from genuine failures.
+func (h *handlers) serverError(apiResp *ApiResp, err error) {
+	switch err {
+	case utils.ErrCanceled:
+		apiResp.Set(StatusClientClosedRequest, "Client Closed Request", nil)
+	case utils.ErrTimeout:
+		apiResp.Set(http.StatusGatewayTimeout, http.StatusText(http.StatusGatewayTimeout), nil)
+		h.logger.Logger().Warn(err)
+	default:
+		apiResp.Set(http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError), nil)
+		h.logger.Logger().Error(err)
+	}
+}
+
 type ApiResp struct {
 	Code    int         `json:"code"`
 	Message string      `json:"message"`
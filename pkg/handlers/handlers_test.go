@@ -1,6 +1,7 @@
 package handlers_test
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,7 +11,6 @@ import (
 	"testing"
 	"xm/configs"
 	"xm/gateways"
-	"xm/pkg/db"
 	"xm/pkg/handlers"
 	"xm/pkg/logger"
 	"xm/pkg/repositories"
@@ -25,6 +25,8 @@ import (
 )
 
 func TestSignUp(t *testing.T) {
+	t.Setenv("XM_AUTH_ADMINS", "admin")
+
 	h, m := getTestHandler(t)
 
 	tests := []struct {
@@ -76,6 +78,13 @@ func TestSignUp(t *testing.T) {
 			status:   500,
 			err:      errors.New("some error"),
 		},
+		{
+			name:     "admin name",
+			data:     `{"username": "admin", "password": "password"}`,
+			expected: `{"code":400,"message":"Bad Request","payload":"already registered"}`,
+			status:   400,
+			err:      nil,
+		},
 	}
 
 	for _, tt := range tests {
@@ -175,17 +184,23 @@ func TestSignIn(t *testing.T) {
 }
 
 func getTestHandler(t *testing.T) (handlers.Handlers, *userMocker) {
+	t.Setenv("XM_DATABASE_PASSWORD", "")
+	t.Setenv("XM_AUTH_JWT_KEY", testJWTKey)
+	t.Setenv("XM_LOGGING_OUTPUTS", "stderr")
+	t.Setenv("XM_STORAGE_DRIVER", "memory")
+
 	var h handlers.Handlers
 	m := &userMocker{}
 
-	go fxtest.New(
-		fxtest.TB(t),
+	// The app is only built, not started, so nothing connects to
+	// postgres or NATS and no lifecycle hook outlives the test.
+	fxtest.New(
+		t,
 		fx.Options(
 			configs.Module,
 			logger.Module,
 			handlers.Module,
-			repositories.Module,
-			db.Module,
+			repositories.Memory,
 			gateways.Module,
 
 			company.Module,
@@ -196,7 +211,7 @@ func getTestHandler(t *testing.T) (handlers.Handlers, *userMocker) {
 			),
 		),
 		fx.Populate(&h),
-	).Run()
+	)
 
 	return h, m
 }
@@ -205,13 +220,18 @@ type userMocker struct {
 	mock.Mock
 }
 
-func (m *userMocker) Create(u *userRepo.User) error {
+func (m *userMocker) Create(_ context.Context, u *userRepo.User) error {
 	args := m.Called(u)
 	return args.Error(0)
 }
 
-func (m *userMocker) GetByUsername(username string) (*userRepo.User, error) {
+func (m *userMocker) GetByUsername(_ context.Context, username string) (*userRepo.User, error) {
 	args := m.Called(username)
 	v, _ := args.Get(0).(*userRepo.User)
 	return v, args.Error(1)
 }
+
+func (m *userMocker) SetPassword(_ context.Context, username, password string) error {
+	args := m.Called(username, password)
+	return args.Error(0)
+}
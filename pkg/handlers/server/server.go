@@ -2,8 +2,19 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"time"
+	"xm/configs"
 	"xm/pkg/handlers"
+	"xm/pkg/health"
+	"xm/pkg/logger"
+	"xm/pkg/metrics"
 
 	"github.com/gorilla/mux"
 	"go.uber.org/fx"
@@ -15,34 +26,122 @@ type Params struct {
 	fx.In
 	Lifecycle fx.Lifecycle
 	Handlers  handlers.Handlers
+	Health    health.Health
+	Configs   configs.Configs
+	Logger    logger.Logger
 }
 
 func Init(p Params) {
 	mux := mux.NewRouter()
 
+	mux.HandleFunc("/healthz", p.Health.Liveness).Methods("GET")
+	mux.HandleFunc("/readyz", p.Health.Readiness).Methods("GET")
+
 	mux.Handle("/sign-up", p.Handlers.LogRequest(http.HandlerFunc(p.Handlers.SignUp))).Methods("POST")
 	mux.Handle("/sign-in", p.Handlers.LogRequest(http.HandlerFunc(p.Handlers.SignIn))).Methods("POST")
 
 	mux.Handle("/company/create", p.Handlers.LogRequest(p.Handlers.Middleware(http.HandlerFunc(p.Handlers.CreateCompany)))).Methods("POST")
 	mux.Handle("/company", p.Handlers.LogRequest(p.Handlers.Middleware(http.HandlerFunc(p.Handlers.GetCompanyByID)))).Methods("GET")
 	mux.Handle("/company", p.Handlers.LogRequest(p.Handlers.Middleware(http.HandlerFunc(p.Handlers.DeleteCompany)))).Methods("DELETE")
+	mux.Handle("/company", p.Handlers.LogRequest(p.Handlers.Middleware(http.HandlerFunc(p.Handlers.ReplaceCompany)))).Methods("PUT")
+	mux.Handle("/company", p.Handlers.LogRequest(p.Handlers.Middleware(http.HandlerFunc(p.Handlers.PatchCompany)))).Methods("PATCH")
+	mux.Handle("/company/restore", p.Handlers.LogRequest(p.Handlers.Middleware(p.Handlers.Admin(http.HandlerFunc(p.Handlers.RestoreCompany))))).Methods("POST")
+	mux.Handle("/company/history", p.Handlers.LogRequest(p.Handlers.Middleware(http.HandlerFunc(p.Handlers.GetCompanyHistory)))).Methods("GET")
 	mux.Handle("/companies", p.Handlers.LogRequest(p.Handlers.Middleware(http.HandlerFunc(p.Handlers.GetAllCompanies)))).Methods("POST")
 	mux.Handle("/company/update", p.Handlers.LogRequest(p.Handlers.Middleware(http.HandlerFunc(p.Handlers.UpdateCompany)))).Methods("PATCH")
 
+	mux.Handle("/admin/metrics", p.Handlers.LogRequest(p.Handlers.Middleware(p.Handlers.Admin(metrics.Handler())))).Methods("GET")
+
+	cfg := p.Configs.Peek().Server
+
 	server := http.Server{
-		Addr:    ":8081",
-		Handler: mux,
+		Addr:              cfg.Addr,
+		Handler:           mux,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout.Duration,
+		ReadTimeout:       cfg.ReadTimeout.Duration,
+		WriteTimeout:      cfg.WriteTimeout.Duration,
+		IdleTimeout:       cfg.IdleTimeout.Duration,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
 	}
 
 	p.Lifecycle.Append(
 		fx.Hook{
 			OnStart: func(ctx context.Context) error {
-				go server.ListenAndServe()
+				tlsConfig, err := newTLSConfig(cfg.TLS)
+				if err != nil {
+					return err
+				}
+
+				ln, err := net.Listen("tcp", server.Addr)
+				if err != nil {
+					return err
+				}
+
+				if tlsConfig != nil {
+					server.TLSConfig = tlsConfig
+					ln = tls.NewListener(ln, tlsConfig)
+				}
+
+				go func() {
+					err := server.Serve(ln)
+					if err != nil && !errors.Is(err, http.ErrServerClosed) {
+						p.Logger.Logger().Error(err)
+					}
+				}()
+
 				return nil
 			},
 			OnStop: func(ctx context.Context) error {
+				p.Health.SetDraining()
+
+				select {
+				case <-time.After(cfg.DrainDelay.Duration):
+				case <-ctx.Done():
+				}
+
 				return server.Shutdown(ctx)
 			},
 		},
 	)
 }
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"none":               tls.NoClientCert,
+	"request":            tls.RequestClientCert,
+	"require":            tls.RequireAnyClientCert,
+	"verify-if-given":    tls.VerifyClientCertIfGiven,
+	"require-and-verify": tls.RequireAndVerifyClientCert,
+}
+
+func newTLSConfig(cfg configs.TLS) (*tls.Config, error) {
+	if cfg.CertFile == "" {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   clientAuthTypes[cfg.ClientAuth],
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("server: no certificates found in %s", cfg.ClientCAFile)
+		}
+
+		tlsConfig.ClientCAs = pool
+	}
+
+	return tlsConfig, nil
+}
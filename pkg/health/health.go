@@ -0,0 +1,171 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/fx"
+)
+
+var Module = fx.Provide(New)
+
+// Group is the fx value group readiness checks are registered in:
+//
+//	fx.Provide(fx.Annotated{Group: health.Group, Target: NewCheck})
+const Group = "health_checks"
+
+const defaultTimeout = 2 * time.Second
+
+type Check struct {
+	Name    string
+	Timeout time.Duration
+	Run     func(ctx context.Context) error
+}
+
+type Health interface {
+	Liveness(w http.ResponseWriter, r *http.Request)
+	Readiness(w http.ResponseWriter, r *http.Request)
+	SetDraining()
+}
+
+type health struct {
+	checks   []Check
+	draining int32
+}
+
+type Params struct {
+	fx.In
+	Checks []Check `group:"health_checks"`
+}
+
+func New(p Params) Health {
+	return &health{
+		checks: p.Checks,
+	}
+}
+
+type Result struct {
+	Status  string  `json:"status"`
+	Latency float64 `json:"latencyMs"`
+	Error   string  `json:"error,omitempty"`
+}
+
+type Report struct {
+	Status   string            `json:"status"`
+	Draining bool              `json:"draining"`
+	Checks   map[string]Result `json:"checks"`
+}
+
+func (h *health) Liveness(w http.ResponseWriter, r *http.Request) {
+	respond(w, http.StatusOK, "ok")
+}
+
+func (h *health) Readiness(w http.ResponseWriter, r *http.Request) {
+	report := h.run(r.Context())
+
+	code := http.StatusOK
+	if report.Status != "ok" {
+		code = http.StatusServiceUnavailable
+	}
+
+	respond(w, code, report)
+}
+
+// SetDraining marks the service as shutting down, so readiness fails
+// while in-flight requests finish.
+func (h *health) SetDraining() {
+	atomic.StoreInt32(&h.draining, 1)
+}
+
+func (h *health) run(ctx context.Context) Report {
+	report := Report{
+		Status:   "ok",
+		Draining: atomic.LoadInt32(&h.draining) == 1,
+		Checks:   make(map[string]Result, len(h.checks)),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, c := range h.checks {
+		wg.Add(1)
+		go func(c Check) {
+			defer wg.Done()
+
+			res := runCheck(ctx, c)
+
+			mu.Lock()
+			report.Checks[c.Name] = res
+			mu.Unlock()
+		}(c)
+	}
+
+	wg.Wait()
+
+	for _, res := range report.Checks {
+		if res.Status != "ok" {
+			report.Status = "fail"
+		}
+	}
+
+	if report.Draining {
+		report.Status = "draining"
+	}
+
+	return report
+}
+
+func runCheck(ctx context.Context, c Check) Result {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Run(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	res := Result{
+		Status:  "ok",
+		Latency: float64(time.Since(start).Microseconds()) / 1000,
+	}
+
+	if err != nil {
+		res.Status = "fail"
+		res.Error = err.Error()
+	}
+
+	return res
+}
+
+func respond(w http.ResponseWriter, code int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	w.WriteHeader(code)
+
+	resp, _ := json.Marshal(struct {
+		Code    int         `json:"code"`
+		Message string      `json:"message"`
+		Payload interface{} `json:"payload"`
+	}{code, http.StatusText(code), payload})
+
+	w.Write(resp)
+}
@@ -0,0 +1,96 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"xm/pkg/health"
+
+	"github.com/stretchr/testify/require"
+)
+
+type response struct {
+	Code    int           `json:"code"`
+	Payload health.Report `json:"payload"`
+}
+
+func TestReadiness(t *testing.T) {
+	tests := []struct {
+		name   string
+		checks []health.Check
+		status int
+		want   map[string]string
+	}{
+		{
+			name: "ok",
+			checks: []health.Check{
+				{Name: "a", Run: func(ctx context.Context) error { return nil }},
+				{Name: "b", Run: func(ctx context.Context) error { return nil }},
+			},
+			status: 200,
+			want:   map[string]string{"a": "ok", "b": "ok"},
+		},
+		{
+			name: "failing check",
+			checks: []health.Check{
+				{Name: "a", Run: func(ctx context.Context) error { return nil }},
+				{Name: "b", Run: func(ctx context.Context) error { return errors.New("down") }},
+			},
+			status: 503,
+			want:   map[string]string{"a": "ok", "b": "fail"},
+		},
+		{
+			name: "timeout",
+			checks: []health.Check{
+				{Name: "slow", Timeout: 10 * time.Millisecond, Run: func(ctx context.Context) error {
+					time.Sleep(time.Second)
+					return nil
+				}},
+			},
+			status: 503,
+			want:   map[string]string{"slow": "fail"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := health.New(health.Params{Checks: tt.checks})
+
+			rr := httptest.NewRecorder()
+			h.Readiness(rr, httptest.NewRequest("GET", "/readyz", nil))
+
+			require.Equal(t, tt.status, rr.Code)
+
+			var resp response
+			require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+
+			got := make(map[string]string)
+			for name, res := range resp.Payload.Checks {
+				got[name] = res.Status
+			}
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestReadinessDraining(t *testing.T) {
+	h := health.New(health.Params{})
+
+	rr := httptest.NewRecorder()
+	h.Liveness(rr, httptest.NewRequest("GET", "/healthz", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	h.SetDraining()
+
+	rr = httptest.NewRecorder()
+	h.Readiness(rr, httptest.NewRequest("GET", "/readyz", nil))
+	require.Equal(t, http.StatusServiceUnavailable, rr.Code)
+
+	rr = httptest.NewRecorder()
+	h.Liveness(rr, httptest.NewRequest("GET", "/healthz", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+}
@@ -1,8 +1,14 @@
 package logger
 
 import (
+	"context"
+	"os"
+	"xm/configs"
+
 	"go.uber.org/fx"
+	"go.uber.org/multierr"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 var Module = fx.Provide(New)
@@ -17,22 +23,96 @@ type logger struct {
 
 type Params struct {
 	fx.In
+	Lifecycle fx.Lifecycle
+	Configs   configs.Configs
 }
 
-func New(p Params) Logger {
-	cfg := zap.NewProductionConfig()
-	cfg.OutputPaths = []string{
-		"xm.log",
+func New(p Params) (Logger, error) {
+	cfg := p.Configs.Peek().Logging
+
+	level := zap.NewAtomicLevelAt(parseLevel(cfg.Level))
+
+	sinks, files, err := openSinks(cfg)
+	if err != nil {
+		return nil, err
 	}
-	cfg.DisableStacktrace = true
-	l, _ := cfg.Build()
+
+	core := zapcore.NewCore(newEncoder(cfg.Encoding), zapcore.NewMultiWriteSyncer(sinks...), level)
+	l := zap.New(core, zap.AddCaller(), zap.ErrorOutput(zapcore.Lock(os.Stderr)))
 	sl := l.Sugar()
 
+	p.Configs.Subscribe(func() {
+		level.SetLevel(parseLevel(p.Configs.Peek().Logging.Level))
+	})
+	p.Configs.SubscribeErrors(func(err error) {
+		sl.Errorw("config reload", "error", err)
+	})
+
+	p.Lifecycle.Append(
+		fx.Hook{
+			OnStop: func(ctx context.Context) error {
+				_ = l.Sync()
+
+				var err error
+				for _, f := range files {
+					err = multierr.Append(err, f.Close())
+				}
+
+				return err
+			},
+		},
+	)
+
 	return &logger{
 		logger: sl,
-	}
+	}, nil
 }
 
 func (l *logger) Logger() *zap.SugaredLogger {
 	return l.logger
 }
+
+func newEncoder(encoding string) zapcore.Encoder {
+	cfg := zap.NewProductionEncoderConfig()
+	cfg.EncodeTime = zapcore.ISO8601TimeEncoder
+
+	if encoding == "console" {
+		cfg.EncodeLevel = zapcore.CapitalLevelEncoder
+		return zapcore.NewConsoleEncoder(cfg)
+	}
+
+	return zapcore.NewJSONEncoder(cfg)
+}
+
+func openSinks(cfg configs.Logging) (sinks []zapcore.WriteSyncer, files []*rotator, err error) {
+	for _, output := range cfg.Outputs {
+		switch output {
+		case "stdout":
+			sinks = append(sinks, zapcore.Lock(os.Stdout))
+		case "stderr":
+			sinks = append(sinks, zapcore.Lock(os.Stderr))
+		default:
+			f, err := newRotator(output, cfg.Rotation)
+			if err != nil {
+				for _, f := range files {
+					_ = f.Close()
+				}
+				return nil, nil, err
+			}
+
+			sinks = append(sinks, f)
+			files = append(files, f)
+		}
+	}
+
+	return
+}
+
+func parseLevel(s string) zapcore.Level {
+	var level zapcore.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return zapcore.InfoLevel
+	}
+
+	return level
+}
@@ -0,0 +1,164 @@
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+	"xm/configs"
+)
+
+const backupTimeFormat = "20060102T150405.000"
+
+// rotator is a log file that moves itself aside to <path>.<timestamp>
+// once it gets too big or too old, and prunes old backups.
+type rotator struct {
+	mu sync.Mutex
+
+	path      string
+	maxSize   int64
+	maxAge    time.Duration
+	backups   int
+	retention time.Duration
+
+	file     *os.File
+	size     int64
+	openedAt time.Time
+}
+
+func newRotator(path string, cfg configs.Rotation) (*rotator, error) {
+	r := &rotator{
+		path:      path,
+		maxSize:   int64(cfg.MaxSizeMB) * 1024 * 1024,
+		maxAge:    cfg.MaxAge.Duration,
+		backups:   cfg.MaxBackups,
+		retention: cfg.Retention.Duration,
+	}
+
+	err := r.open()
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *rotator) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.due(len(p)) {
+		err := r.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+
+	return n, err
+}
+
+func (r *rotator) Sync() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.file.Sync()
+}
+
+func (r *rotator) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.file.Close()
+}
+
+func (r *rotator) due(n int) bool {
+	if r.size == 0 {
+		return false
+	}
+
+	if r.maxSize > 0 && r.size+int64(n) > r.maxSize {
+		return true
+	}
+
+	return r.maxAge > 0 && time.Since(r.openedAt) >= r.maxAge
+}
+
+func (r *rotator) open() error {
+	err := os.MkdirAll(filepath.Dir(r.path), 0o755)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	r.file = f
+	r.size = info.Size()
+	r.openedAt = time.Now()
+
+	return nil
+}
+
+func (r *rotator) rotate() error {
+	err := r.file.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(r.path, r.path+"."+time.Now().Format(backupTimeFormat))
+	if err != nil {
+		return r.reopen(err)
+	}
+
+	err = r.open()
+	if err != nil {
+		return r.reopen(err)
+	}
+
+	r.prune()
+
+	return nil
+}
+
+// reopen goes back to appending to path after a failed rotation, so that
+// later writes are not stuck with a closed file, and returns err.
+func (r *rotator) reopen(err error) error {
+	_ = r.open()
+
+	return err
+}
+
+func (r *rotator) prune() {
+	backups, err := filepath.Glob(r.path + ".*")
+	if err != nil {
+		return
+	}
+
+	// the timestamp suffix sorts oldest first
+	sort.Strings(backups)
+
+	for i, backup := range backups {
+		expired := r.backups > 0 && i < len(backups)-r.backups
+
+		if !expired && r.retention > 0 {
+			info, err := os.Stat(backup)
+			expired = err == nil && time.Since(info.ModTime()) > r.retention
+		}
+
+		if expired {
+			_ = os.Remove(backup)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"xm/configs"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotateBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "xm.log")
+
+	r, err := newRotator(path, configs.Rotation{MaxBackups: 2})
+	require.NoError(t, err)
+	defer r.Close()
+
+	r.maxSize = 10
+
+	for i := 0; i < 4; i++ {
+		_, err = r.Write([]byte("0123456789"))
+		require.NoError(t, err)
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	backups, err := filepath.Glob(path + ".*")
+	require.NoError(t, err)
+	require.Len(t, backups, 2)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "0123456789", string(data))
+}
+
+func TestRotateByAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "xm.log")
+
+	r, err := newRotator(path, configs.Rotation{MaxAge: configs.Duration{Duration: time.Hour}})
+	require.NoError(t, err)
+	defer r.Close()
+
+	_, err = r.Write([]byte("old\n"))
+	require.NoError(t, err)
+
+	r.openedAt = time.Now().Add(-2 * time.Hour)
+
+	_, err = r.Write([]byte("new\n"))
+	require.NoError(t, err)
+
+	backups, err := filepath.Glob(path + ".*")
+	require.NoError(t, err)
+	require.Len(t, backups, 1)
+
+	data, err := os.ReadFile(backups[0])
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(string(data), "old"))
+}
+
+func TestRotateFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "xm.log")
+
+	r, err := newRotator(path, configs.Rotation{})
+	require.NoError(t, err)
+	defer r.Close()
+
+	r.maxSize = 10
+
+	_, err = r.Write([]byte("0123456789"))
+	require.NoError(t, err)
+
+	// nothing left to rename
+	require.NoError(t, os.Remove(path))
+
+	_, err = r.Write([]byte("lost"))
+	require.Error(t, err)
+
+	_, err = r.Write([]byte("kept"))
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "kept", string(data))
+}
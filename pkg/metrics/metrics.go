@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+var (
+	mu    sync.Mutex
+	funcs = make(map[string]func() interface{})
+)
+
+// Func exposes the value returned by fn under name on the metrics
+// endpoint. Registering a name again replaces the earlier fn, so a
+// component can be rebuilt, as it is in tests, without a duplicate panic.
+func Func(name string, fn func() interface{}) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	funcs[name] = fn
+}
+
+// Handler serves the metrics registered with Func as a JSON object. Unlike
+// expvar it leaves out the command line, which may carry secrets.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fns := make(map[string]func() interface{}, len(funcs))
+		for name, fn := range funcs {
+			fns[name] = fn
+		}
+		mu.Unlock()
+
+		vars := make(map[string]interface{}, len(fns))
+		for name, fn := range fns {
+			vars[name] = fn()
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(vars)
+	})
+}
@@ -0,0 +1,24 @@
+package metrics_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"xm/pkg/metrics"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFunc(t *testing.T) {
+	metrics.Func("test_value", func() interface{} { return 1 })
+	metrics.Func("test_value", func() interface{} { return 2 })
+
+	rr := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/admin/metrics", nil))
+
+	var vars map[string]interface{}
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&vars))
+	require.Equal(t, float64(2), vars["test_value"])
+	require.NotContains(t, vars, "cmdline")
+	require.NotContains(t, vars, "memstats")
+}
@@ -0,0 +1,392 @@
+// Package patch applies JSON Merge Patch (RFC 7396) and JSON Patch
+// (RFC 6902) documents to JSON documents.
+package patch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	MergePatchType = "application/merge-patch+json"
+	JSONPatchType  = "application/json-patch+json"
+)
+
+var (
+	// ErrInvalid reports a patch document that is not well formed.
+	ErrInvalid = errors.New("invalid patch")
+	// ErrTestFailed reports a JSON Patch "test" operation that did not hold.
+	ErrTestFailed = errors.New("test operation failed")
+	// ErrPath reports a path that does not lead where the operation needs.
+	ErrPath = errors.New("path not found")
+)
+
+// Merge applies an RFC 7396 merge patch to doc: objects are merged
+// recursively, null removes a member and anything else replaces it.
+func Merge(doc, patch []byte) ([]byte, error) {
+	target, err := decode(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := decode(patch)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+
+	return json.Marshal(merge(target, p))
+}
+
+func merge(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{})
+	}
+
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+
+		t[k] = merge(t[k], v)
+	}
+
+	return t
+}
+
+type operation struct {
+	Op    string          `json:"op"`
+	Path  *string         `json:"path"`
+	From  *string         `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// Apply applies an RFC 6902 JSON Patch to doc. Operations run in order
+// and the first one that fails aborts the whole patch.
+func Apply(doc, patch []byte) ([]byte, error) {
+	var ops []operation
+
+	dec := json.NewDecoder(bytes.NewReader(patch))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(&ops)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+
+	target, err := decode(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, op := range ops {
+		target, err = op.apply(target)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d (%s): %w", i, op.Op, err)
+		}
+	}
+
+	return json.Marshal(target)
+}
+
+func (op operation) apply(doc interface{}) (interface{}, error) {
+	if op.Path == nil {
+		return nil, fmt.Errorf("%w: missing path", ErrInvalid)
+	}
+
+	path, err := pointer(*op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, fmt.Errorf("%w: missing value", ErrInvalid)
+		}
+
+		value, err := decode(op.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+
+		switch op.Op {
+		case "add":
+			return add(doc, path, value)
+		case "replace":
+			return update(doc, path, func(interface{}) (interface{}, error) { return value, nil })
+		}
+
+		current, err := get(doc, path)
+		if err != nil {
+			return nil, err
+		}
+
+		if !equal(current, value) {
+			return nil, fmt.Errorf("%w: %s", ErrTestFailed, *op.Path)
+		}
+
+		return doc, nil
+	case "remove":
+		return remove(doc, path)
+	case "move", "copy":
+		if op.From == nil {
+			return nil, fmt.Errorf("%w: missing from", ErrInvalid)
+		}
+
+		from, err := pointer(*op.From)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := get(doc, from)
+		if err != nil {
+			return nil, err
+		}
+
+		if op.Op == "copy" {
+			return add(doc, path, clone(value))
+		}
+
+		if strings.HasPrefix(*op.Path+"/", *op.From+"/") && *op.Path != *op.From {
+			return nil, fmt.Errorf("%w: cannot move %s into itself", ErrInvalid, *op.From)
+		}
+
+		doc, err = remove(doc, from)
+		if err != nil {
+			return nil, err
+		}
+
+		return add(doc, path, value)
+	default:
+		return nil, fmt.Errorf("%w: unknown op %q", ErrInvalid, op.Op)
+	}
+}
+
+// pointer splits an RFC 6901 JSON Pointer into its reference tokens.
+func pointer(p string) ([]string, error) {
+	if p == "" {
+		return nil, nil
+	}
+
+	if p[0] != '/' {
+		return nil, fmt.Errorf("%w: pointer %q must start with /", ErrInvalid, p)
+	}
+
+	tokens := strings.Split(p[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(t)
+	}
+
+	return tokens, nil
+}
+
+func get(doc interface{}, path []string) (interface{}, error) {
+	var value interface{}
+
+	_, err := update(doc, path, func(v interface{}) (interface{}, error) {
+		value = v
+		return v, nil
+	})
+
+	return value, err
+}
+
+// update replaces the value at path with what fn returns for it.
+func update(doc interface{}, path []string, fn func(interface{}) (interface{}, error)) (interface{}, error) {
+	if len(path) == 0 {
+		return fn(doc)
+	}
+
+	key, rest := path[0], path[1:]
+
+	switch d := doc.(type) {
+	case map[string]interface{}:
+		child, ok := d[key]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrPath, key)
+		}
+
+		v, err := update(child, rest, fn)
+		if err != nil {
+			return nil, err
+		}
+		d[key] = v
+
+		return d, nil
+	case []interface{}:
+		i, err := index(key, len(d)-1)
+		if err != nil {
+			return nil, err
+		}
+
+		v, err := update(d[i], rest, fn)
+		if err != nil {
+			return nil, err
+		}
+		d[i] = v
+
+		return d, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrPath, key)
+	}
+}
+
+func add(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	key := path[len(path)-1]
+
+	return update(doc, path[:len(path)-1], func(parent interface{}) (interface{}, error) {
+		switch p := parent.(type) {
+		case map[string]interface{}:
+			p[key] = value
+			return p, nil
+		case []interface{}:
+			i := len(p)
+			if key != "-" {
+				var err error
+				i, err = index(key, len(p))
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			p = append(p, nil)
+			copy(p[i+1:], p[i:])
+			p[i] = value
+
+			return p, nil
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrPath, key)
+		}
+	})
+}
+
+func remove(doc interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("%w: cannot remove the whole document", ErrInvalid)
+	}
+
+	key := path[len(path)-1]
+
+	return update(doc, path[:len(path)-1], func(parent interface{}) (interface{}, error) {
+		switch p := parent.(type) {
+		case map[string]interface{}:
+			if _, ok := p[key]; !ok {
+				return nil, fmt.Errorf("%w: %s", ErrPath, key)
+			}
+			delete(p, key)
+
+			return p, nil
+		case []interface{}:
+			i, err := index(key, len(p)-1)
+			if err != nil {
+				return nil, err
+			}
+
+			return append(p[:i], p[i+1:]...), nil
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrPath, key)
+		}
+	})
+}
+
+// index parses an array index token, which must be a plain decimal
+// number no greater than max.
+func index(token string, max int) (int, error) {
+	if token == "" || (len(token) > 1 && token[0] == '0') || strings.TrimLeft(token, "0123456789") != "" {
+		return 0, fmt.Errorf("%w: bad array index %q", ErrPath, token)
+	}
+
+	i, err := strconv.Atoi(token)
+	if err != nil || i > max {
+		return 0, fmt.Errorf("%w: array index %s out of range", ErrPath, token)
+	}
+
+	return i, nil
+}
+
+func decode(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var v interface{}
+	err := dec.Decode(&v)
+	if err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+func clone(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			c[k] = clone(e)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(v))
+		for i, e := range v {
+			c[i] = clone(e)
+		}
+		return c
+	default:
+		return v
+	}
+}
+
+// equal compares decoded JSON values, numbers by value so 1 and 1.0
+// are the same.
+func equal(a, b interface{}) bool {
+	switch a := a.(type) {
+	case map[string]interface{}:
+		b, ok := b.(map[string]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, v := range a {
+			w, ok := b[k]
+			if !ok || !equal(v, w) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !equal(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		b, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		x, errA := a.Float64()
+		y, errB := b.Float64()
+		return errA == nil && errB == nil && x == y
+	default:
+		return a == b
+	}
+}
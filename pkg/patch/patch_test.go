@@ -0,0 +1,163 @@
+package patch_test
+
+import (
+	"testing"
+	"xm/pkg/patch"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name     string
+		doc      string
+		patch    string
+		expected string
+	}{
+		{name: "replace", doc: `{"a":"b"}`, patch: `{"a":"c"}`, expected: `{"a":"c"}`},
+		{name: "add", doc: `{"a":"b"}`, patch: `{"b":"c"}`, expected: `{"a":"b","b":"c"}`},
+		{name: "remove", doc: `{"a":"b","b":"c"}`, patch: `{"a":null}`, expected: `{"b":"c"}`},
+		{name: "empty string", doc: `{"a":"b"}`, patch: `{"a":""}`, expected: `{"a":""}`},
+		{name: "array replaced", doc: `{"a":[1,2]}`, patch: `{"a":[3]}`, expected: `{"a":[3]}`},
+		{name: "nested", doc: `{"a":{"b":"c","d":"e"}}`, patch: `{"a":{"d":null,"f":1}}`, expected: `{"a":{"b":"c","f":1}}`},
+		{name: "not an object", doc: `{"a":"b"}`, patch: `["c"]`, expected: `["c"]`},
+		{name: "keeps numbers", doc: `{"a":12345678901234567890}`, patch: `{}`, expected: `{"a":12345678901234567890}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := patch.Merge([]byte(tt.doc), []byte(tt.patch))
+			require.NoError(t, err)
+			require.JSONEq(t, tt.expected, string(out))
+		})
+	}
+
+	_, err := patch.Merge([]byte(`{}`), []byte(`{`))
+	require.ErrorIs(t, err, patch.ErrInvalid)
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name     string
+		doc      string
+		patch    string
+		expected string
+		err      error
+	}{
+		{
+			name:     "add",
+			doc:      `{"a":"b"}`,
+			patch:    `[{"op":"add","path":"/c","value":"d"}]`,
+			expected: `{"a":"b","c":"d"}`,
+		},
+		{
+			name:     "add to array",
+			doc:      `{"a":[1,3]}`,
+			patch:    `[{"op":"add","path":"/a/1","value":2},{"op":"add","path":"/a/-","value":4}]`,
+			expected: `{"a":[1,2,3,4]}`,
+		},
+		{
+			name:     "remove",
+			doc:      `{"a":"b","c":[1,2]}`,
+			patch:    `[{"op":"remove","path":"/a"},{"op":"remove","path":"/c/0"}]`,
+			expected: `{"c":[2]}`,
+		},
+		{
+			name:     "replace",
+			doc:      `{"a":"b"}`,
+			patch:    `[{"op":"replace","path":"/a","value":""}]`,
+			expected: `{"a":""}`,
+		},
+		{
+			name:     "move",
+			doc:      `{"a":{"b":"c"}}`,
+			patch:    `[{"op":"move","from":"/a/b","path":"/d"}]`,
+			expected: `{"a":{},"d":"c"}`,
+		},
+		{
+			name:     "copy",
+			doc:      `{"a":{"b":"c"}}`,
+			patch:    `[{"op":"copy","from":"/a","path":"/d"},{"op":"replace","path":"/d/b","value":"e"}]`,
+			expected: `{"a":{"b":"c"},"d":{"b":"e"}}`,
+		},
+		{
+			name:     "test",
+			doc:      `{"a":1,"b":"c"}`,
+			patch:    `[{"op":"test","path":"/a","value":1.0},{"op":"replace","path":"/b","value":"d"}]`,
+			expected: `{"a":1,"b":"d"}`,
+		},
+		{
+			name:     "escaped pointer",
+			doc:      `{"a/b":1,"c~d":2}`,
+			patch:    `[{"op":"remove","path":"/a~1b"},{"op":"remove","path":"/c~0d"}]`,
+			expected: `{}`,
+		},
+		{
+			name:     "whole document",
+			doc:      `{"a":1}`,
+			patch:    `[{"op":"replace","path":"","value":{"b":2}}]`,
+			expected: `{"b":2}`,
+		},
+		{
+			name:  "test fails",
+			doc:   `{"a":"b"}`,
+			patch: `[{"op":"test","path":"/a","value":"c"}]`,
+			err:   patch.ErrTestFailed,
+		},
+		{
+			name:  "missing member",
+			doc:   `{"a":"b"}`,
+			patch: `[{"op":"replace","path":"/c","value":"d"}]`,
+			err:   patch.ErrPath,
+		},
+		{
+			name:  "index out of range",
+			doc:   `{"a":[1]}`,
+			patch: `[{"op":"add","path":"/a/2","value":2}]`,
+			err:   patch.ErrPath,
+		},
+		{
+			name:  "leading zero",
+			doc:   `{"a":[1,2]}`,
+			patch: `[{"op":"remove","path":"/a/01"}]`,
+			err:   patch.ErrPath,
+		},
+		{
+			name:  "move into itself",
+			doc:   `{"a":{"b":{}}}`,
+			patch: `[{"op":"move","from":"/a","path":"/a/b/c"}]`,
+			err:   patch.ErrInvalid,
+		},
+		{
+			name:  "unknown op",
+			doc:   `{}`,
+			patch: `[{"op":"merge","path":"/a","value":1}]`,
+			err:   patch.ErrInvalid,
+		},
+		{
+			name:  "missing value",
+			doc:   `{}`,
+			patch: `[{"op":"add","path":"/a"}]`,
+			err:   patch.ErrInvalid,
+		},
+		{
+			name:  "not a list",
+			doc:   `{}`,
+			patch: `{"op":"add","path":"/a","value":1}`,
+			err:   patch.ErrInvalid,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := patch.Apply([]byte(tt.doc), []byte(tt.patch))
+			if tt.err != nil {
+				require.ErrorIs(t, err, tt.err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.JSONEq(t, tt.expected, string(out))
+		})
+	}
+}
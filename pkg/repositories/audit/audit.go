@@ -0,0 +1,129 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+	"xm/pkg/db"
+
+	"go.uber.org/fx"
+)
+
+var Module = fx.Provide(New)
+
+// Actions recorded for companies.
+const (
+	ActionCreate  = "create"
+	ActionUpdate  = "update"
+	ActionReplace = "replace"
+	ActionDelete  = "delete"
+	ActionRestore = "restore"
+	ActionPurge   = "purge"
+)
+
+type Repository interface {
+	Create(ctx context.Context, e Entry) (err error)
+	GetByCompanyID(ctx context.Context, companyID, limit, offset int) (entries []Entry, err error)
+}
+
+type repository struct {
+	db db.Database
+}
+
+type Params struct {
+	fx.In
+	DB db.Database
+}
+
+// Entry is one change to a company. ActorID is zero for changes no user
+// asked for, such as purges. Before is empty on create and After on
+// purge.
+type Entry struct {
+	ID        int64           `json:"id"`
+	CompanyID int             `json:"companyId"`
+	ActorID   int             `json:"actorId"`
+	Action    string          `json:"action"`
+	Before    json.RawMessage `json:"before,omitempty"`
+	After     json.RawMessage `json:"after,omitempty"`
+	RequestID string          `json:"requestId"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+func New(p Params) Repository {
+	return &repository{
+		db: p.DB,
+	}
+}
+
+// Create records e; run it in the transaction of the change it describes.
+func (r *repository) Create(ctx context.Context, e Entry) (err error) {
+	ctx, cancel := r.db.Query(ctx, "audit.Create")
+	defer cancel()
+
+	query := `
+		INSERT INTO company_audit(company_id, actor_id, action, before, after, request_id)
+		VALUES($1, NULLIF($2, 0), $3, $4, $5, $6)
+	`
+
+	_, err = r.db.Executor(ctx).ExecContext(ctx, query, e.CompanyID, e.ActorID, e.Action, jsonb(e.Before), jsonb(e.After), e.RequestID)
+
+	return
+}
+
+// GetByCompanyID pages through the history of a company, oldest first.
+func (r *repository) GetByCompanyID(ctx context.Context, companyID, limit, offset int) (entries []Entry, err error) {
+	ctx, cancel := r.db.Query(ctx, "audit.GetByCompanyID")
+	defer cancel()
+
+	query := `
+		SELECT
+			id, company_id, COALESCE(actor_id, 0), action, before, after, request_id, created_at
+		FROM company_audit
+		WHERE company_id = $1
+		ORDER BY id
+		LIMIT $2 OFFSET $3
+	`
+
+	rows, err := r.db.Reader(ctx).QueryContext(ctx, query, companyID, limit, offset)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			e             Entry
+			before, after []byte
+		)
+
+		err = rows.Scan(&e.ID, &e.CompanyID, &e.ActorID, &e.Action, &before, &after, &e.RequestID, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		e.Before, e.After = before, after
+		entries = append(entries, e)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(entries) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	return
+}
+
+// jsonb passes a snapshot as text, which postgres parses into jsonb;
+// lib/pq would send a []byte as bytea.
+func jsonb(b json.RawMessage) interface{} {
+	if len(b) == 0 {
+		return nil
+	}
+
+	return string(b)
+}
@@ -0,0 +1,63 @@
+package audit_test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"testing"
+	"xm/pkg/db/dbtest"
+	"xm/pkg/repositories/audit"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAudit(t *testing.T) {
+	t.Parallel()
+
+	testRepository(t, dbtest.New(t).Audit)
+}
+
+func TestMemory(t *testing.T) {
+	testRepository(t, audit.NewMemory())
+}
+
+func testRepository(t *testing.T, repo audit.Repository) {
+	ctx := context.Background()
+
+	entries := []audit.Entry{
+		{CompanyID: 1, ActorID: 7, Action: audit.ActionCreate, After: json.RawMessage(`{"name":"a"}`), RequestID: "req1"},
+		{CompanyID: 2, ActorID: 7, Action: audit.ActionCreate, After: json.RawMessage(`{"name":"b"}`)},
+		{CompanyID: 1, ActorID: 8, Action: audit.ActionUpdate, Before: json.RawMessage(`{"name":"a"}`), After: json.RawMessage(`{"name":"c"}`)},
+		{CompanyID: 1, Action: audit.ActionPurge},
+	}
+
+	for _, e := range entries {
+		require.NoError(t, repo.Create(ctx, e))
+	}
+
+	history, err := repo.GetByCompanyID(ctx, 1, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, history, 3)
+
+	require.Equal(t, audit.ActionCreate, history[0].Action)
+	require.Equal(t, 7, history[0].ActorID)
+	require.Equal(t, "req1", history[0].RequestID)
+	require.Empty(t, history[0].Before)
+	require.JSONEq(t, `{"name":"a"}`, string(history[0].After))
+	require.False(t, history[0].CreatedAt.IsZero())
+
+	require.JSONEq(t, `{"name":"a"}`, string(history[1].Before))
+	require.Equal(t, 0, history[2].ActorID)
+	require.Empty(t, history[2].After)
+
+	history, err = repo.GetByCompanyID(ctx, 1, 1, 1)
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	require.Equal(t, audit.ActionUpdate, history[0].Action)
+
+	_, err = repo.GetByCompanyID(ctx, 1, 10, 3)
+	require.Equal(t, sql.ErrNoRows, err)
+
+	_, err = repo.GetByCompanyID(ctx, 3, 10, 0)
+	require.Equal(t, sql.ErrNoRows, err)
+}
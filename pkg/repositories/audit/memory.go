@@ -0,0 +1,68 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+)
+
+type memory struct {
+	mu      sync.RWMutex
+	entries []Entry
+}
+
+// NewMemory returns a Repository that keeps the audit trail in process
+// memory and behaves like the postgres one, for dev runs and tests.
+func NewMemory() Repository {
+	return &memory{}
+}
+
+func (m *memory) Create(ctx context.Context, e Entry) (err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e.ID = int64(len(m.entries) + 1)
+	e.CreatedAt = time.Now()
+
+	m.entries = append(m.entries, e)
+
+	return nil
+}
+
+func (m *memory) GetByCompanyID(ctx context.Context, companyID, limit, offset int) (entries []Entry, err error) {
+	if limit < 0 {
+		return nil, errors.New("LIMIT must not be negative")
+	}
+
+	if offset < 0 {
+		return nil, errors.New("OFFSET must not be negative")
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, e := range m.entries {
+		if len(entries) == limit {
+			break
+		}
+
+		if e.CompanyID != companyID {
+			continue
+		}
+
+		if offset > 0 {
+			offset--
+			continue
+		}
+
+		entries = append(entries, e)
+	}
+
+	if len(entries) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	return
+}
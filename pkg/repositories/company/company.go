@@ -1,7 +1,9 @@
 package company
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"strconv"
 	"time"
 	"xm/pkg/db"
@@ -11,16 +13,25 @@ import (
 
 var Module = fx.Provide(New)
 
+// ErrVersionMismatch is returned by the writes that take a version when
+// the company is not at the version the caller expected.
+var ErrVersionMismatch = errors.New("company: version mismatch")
+
 type Repository interface {
-	Create(c Company) (err error)
-	GetByID(id int) (c Company, err error)
-	GetAll(f Filters) (companies []Company, err error)
-	Update(c Company) (err error)
-	DeleteByID(id int) (err error)
+	Create(ctx context.Context, c Company) (created Company, err error)
+	GetByID(ctx context.Context, id int) (c Company, err error)
+	Lock(ctx context.Context, id int) (c Company, err error)
+	GetAll(ctx context.Context, f Filters) (companies []Company, err error)
+	Update(ctx context.Context, c Company) (updated Company, err error)
+	Replace(ctx context.Context, c Company) (updated Company, err error)
+	DeleteByID(ctx context.Context, id, version int) (err error)
+	Restore(ctx context.Context, id, version int) (restored Company, err error)
+	Purge(ctx context.Context, olderThan time.Duration, limit int) (purged []Company, err error)
+	CountPurgeable(ctx context.Context, olderThan time.Duration) (n int, err error)
 }
 
 type repository struct {
-	db *sql.DB
+	db db.Database
 }
 
 type Params struct {
@@ -36,39 +47,52 @@ type Company struct {
 	Website   string    `json:"website"`
 	Phone     string    `json:"phone"`
 	Status    string    `json:"status"`
+	Version   int       `json:"version"`
 	CreatedAt time.Time `json:"createdAt"`
 	UpdatedAt time.Time `json:"updatedAt"`
+
+	// DeletedAt is set while the company is deleted.
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
 }
 
 func New(p Params) Repository {
 	return &repository{
-		db: p.DB.Connection(),
+		db: p.DB,
 	}
 }
 
-func (r *repository) Create(c Company) (err error) {
+func (r *repository) Create(ctx context.Context, c Company) (created Company, err error) {
+	ctx, cancel := r.db.Query(ctx, "company.Create")
+	defer cancel()
+
 	query := `
 		INSERT INTO companies(name, code, country, website, phone)
 		VALUES($1, $2, $3, $4, $5)
+		RETURNING id, name, code, country, website, phone, status, version, created_at, updated_at, deleted_at
 	`
 
-	_, err = r.db.Exec(query, c.Name, c.Code, c.Country, c.Website, c.Phone)
+	n := &created
+	err = r.db.Executor(ctx).QueryRowContext(ctx, query, c.Name, c.Code, c.Country, c.Website, c.Phone).
+		Scan(&n.ID, &n.Name, &n.Code, &n.Country, &n.Website, &n.Phone, &n.Status, &n.Version, &n.CreatedAt, &n.UpdatedAt, &n.DeletedAt)
 	if err != nil {
-		return
+		return Company{}, err
 	}
 
 	return
 }
 
-func (r *repository) GetByID(id int) (c Company, err error) {
+func (r *repository) GetByID(ctx context.Context, id int) (c Company, err error) {
+	ctx, cancel := r.db.Query(ctx, "company.GetByID")
+	defer cancel()
+
 	query := `
 		SELECT
-			id, name, code, country, website, phone, status, created_at, updated_at
+			id, name, code, country, website, phone, status, version, created_at, updated_at, deleted_at
 		FROM companies
 		WHERE id = $1 AND status = 'active'
 	`
 
-	err = r.db.QueryRow(query, id).Scan(&c.ID, &c.Name, &c.Code, &c.Country, &c.Website, &c.Phone, &c.Status, &c.CreatedAt, &c.UpdatedAt)
+	err = r.db.Reader(ctx).QueryRowContext(ctx, query, id).Scan(&c.ID, &c.Name, &c.Code, &c.Country, &c.Website, &c.Phone, &c.Status, &c.Version, &c.CreatedAt, &c.UpdatedAt, &c.DeletedAt)
 	if err != nil {
 		return
 	}
@@ -76,6 +100,32 @@ func (r *repository) GetByID(id int) (c Company, err error) {
 	return
 }
 
+// Statuses a Filters.Status may ask for; empty means StatusActive.
+const (
+	StatusActive  = "active"
+	StatusDeleted = "deleted"
+	StatusAll     = "all"
+)
+
+// Lock reads the company, deleted or not, and locks it until the end of
+// the transaction on ctx, so a change to it can be recorded exactly.
+func (r *repository) Lock(ctx context.Context, id int) (c Company, err error) {
+	ctx, cancel := r.db.Query(ctx, "company.Lock")
+	defer cancel()
+
+	query := `
+		SELECT
+			id, name, code, country, website, phone, status, version, created_at, updated_at, deleted_at
+		FROM companies
+		WHERE id = $1
+		FOR UPDATE
+	`
+
+	err = r.db.Executor(ctx).QueryRowContext(ctx, query, id).Scan(&c.ID, &c.Name, &c.Code, &c.Country, &c.Website, &c.Phone, &c.Status, &c.Version, &c.CreatedAt, &c.UpdatedAt, &c.DeletedAt)
+
+	return
+}
+
 type Filters struct {
 	ID      int    `json:"id"`
 	Name    string `json:"name"`
@@ -83,20 +133,33 @@ type Filters struct {
 	Country string `json:"country"`
 	Website string `json:"website"`
 	Phone   string `json:"phone"`
+	Status  string `json:"status"`
 	Limit   int    `json:"limit"`
 	Offset  int    `json:"offset"`
 }
 
-func (r *repository) GetAll(f Filters) (companies []Company, err error) {
+// status is the company status f selects, StatusAll matching any.
+func (f Filters) status() string {
+	if f.Status == "" {
+		return StatusActive
+	}
+
+	return f.Status
+}
+
+func (r *repository) GetAll(ctx context.Context, f Filters) (companies []Company, err error) {
+	ctx, cancel := r.db.Query(ctx, "company.GetAll")
+	defer cancel()
+
 	query := `
 		SELECT
-			id, name, code, country, website, phone, status, created_at, updated_at
+			id, name, code, country, website, phone, status, version, created_at, updated_at, deleted_at
 		FROM companies
-		WHERE status = 'active'
+		WHERE ($1 = 'all' OR status = $1)
 	`
 
-	cnt := 1
-	var values []interface{}
+	cnt := 2
+	values := []interface{}{f.status()}
 
 	if f.ID != 0 {
 		query += ` AND id = $` + strconv.Itoa(cnt)
@@ -148,14 +211,15 @@ func (r *repository) GetAll(f Filters) (companies []Company, err error) {
 	cnt++
 	values = append(values, f.Offset)
 
-	rows, err := r.db.Query(query, values...)
+	rows, err := r.db.Reader(ctx).QueryContext(ctx, query, values...)
 	if err != nil {
 		return
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		var c Company
-		err = rows.Scan(&c.ID, &c.Name, &c.Code, &c.Country, &c.Website, &c.Phone, &c.Status, &c.CreatedAt, &c.UpdatedAt)
+		err = rows.Scan(&c.ID, &c.Name, &c.Code, &c.Country, &c.Website, &c.Phone, &c.Status, &c.Version, &c.CreatedAt, &c.UpdatedAt, &c.DeletedAt)
 		if err != nil {
 			return nil, err
 		}
@@ -163,6 +227,11 @@ func (r *repository) GetAll(f Filters) (companies []Company, err error) {
 		companies = append(companies, c)
 	}
 
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
 	if len(companies) == 0 {
 		return nil, sql.ErrNoRows
 	}
@@ -170,58 +239,186 @@ func (r *repository) GetAll(f Filters) (companies []Company, err error) {
 	return
 }
 
-func (r *repository) Update(c Company) (err error) {
-	tx, err := r.db.Begin()
-	if err != nil {
-		return
-	}
+// Update changes the non-empty fields of an active company and returns
+// it as stored afterwards. A non-zero c.Version must match the stored one.
+func (r *repository) Update(ctx context.Context, c Company) (updated Company, err error) {
+	ctx, cancel := r.db.Query(ctx, "company.Update")
+	defer cancel()
 
 	query := `
 		UPDATE companies
 		SET
 			name = COALESCE(NULLIF($1, ''), name), code = COALESCE(NULLIF($2, ''), code),
 			country = COALESCE(NULLIF($3, ''), country), website = COALESCE(NULLIF($4, ''), website),
-			phone = COALESCE(NULLIF($5, ''), phone)
-		WHERE id = $6
+			phone = COALESCE(NULLIF($5, ''), phone), version = version + 1, updated_at = now()
+		WHERE id = $6 AND status = 'active' AND ($7 = 0 OR version = $7)
+		RETURNING id, name, code, country, website, phone, status, version, created_at, updated_at, deleted_at
 	`
 
-	_, err = tx.Exec(query, c.Name, c.Code, c.Country, c.Website, c.Phone, c.ID)
-	if err != nil {
-		_ = tx.Rollback()
-		return
+	u := &updated
+	err = r.db.Executor(ctx).QueryRowContext(ctx, query, c.Name, c.Code, c.Country, c.Website, c.Phone, c.ID, c.Version).
+		Scan(&u.ID, &u.Name, &u.Code, &u.Country, &u.Website, &u.Phone, &u.Status, &u.Version, &u.CreatedAt, &u.UpdatedAt, &u.DeletedAt)
+	if err == sql.ErrNoRows && c.Version != 0 {
+		return Company{}, r.versionMismatch(ctx, c.ID, StatusActive)
+	}
+
+	return
+}
+
+// Replace overwrites every editable field of an active company, empty
+// values included, and returns it as stored afterwards. A non-zero
+// c.Version must match the stored one.
+func (r *repository) Replace(ctx context.Context, c Company) (updated Company, err error) {
+	ctx, cancel := r.db.Query(ctx, "company.Replace")
+	defer cancel()
+
+	query := `
+		UPDATE companies
+		SET
+			name = $1, code = $2, country = $3, website = $4, phone = $5,
+			version = version + 1, updated_at = now()
+		WHERE id = $6 AND status = 'active' AND ($7 = 0 OR version = $7)
+		RETURNING id, name, code, country, website, phone, status, version, created_at, updated_at, deleted_at
+	`
+
+	u := &updated
+	err = r.db.Executor(ctx).QueryRowContext(ctx, query, c.Name, c.Code, c.Country, c.Website, c.Phone, c.ID, c.Version).
+		Scan(&u.ID, &u.Name, &u.Code, &u.Country, &u.Website, &u.Phone, &u.Status, &u.Version, &u.CreatedAt, &u.UpdatedAt, &u.DeletedAt)
+	if err == sql.ErrNoRows && c.Version != 0 {
+		return Company{}, r.versionMismatch(ctx, c.ID, StatusActive)
 	}
 
-	return tx.Commit()
+	return
 }
 
-func (r *repository) DeleteByID(id int) (err error) {
-	tx, err := r.db.Begin()
+// versionMismatch tells a company with the given status at another
+// version, ErrVersionMismatch, from one that is missing or in another
+// status, sql.ErrNoRows. It reads through Executor, the primary, so a
+// lagging replica cannot hide the company.
+func (r *repository) versionMismatch(ctx context.Context, id int, status string) error {
+	query := `
+		SELECT EXISTS(SELECT 1 FROM companies WHERE id = $1 AND status = $2)
+	`
+
+	var exists bool
+	err := r.db.Executor(ctx).QueryRowContext(ctx, query, id, status).Scan(&exists)
 	if err != nil {
-		return
+		return err
+	}
+
+	if exists {
+		return ErrVersionMismatch
 	}
 
+	return sql.ErrNoRows
+}
+
+// DeleteByID soft deletes the company. A non-zero version must match
+// the stored one.
+func (r *repository) DeleteByID(ctx context.Context, id, version int) (err error) {
+	ctx, cancel := r.db.Query(ctx, "company.DeleteByID")
+	defer cancel()
+
 	query := `
 		UPDATE companies
-		SET status = 'deleted'
-		WHERE id = $1 AND status != 'deleted'
+		SET status = 'deleted', version = version + 1, deleted_at = now()
+		WHERE id = $1 AND status != 'deleted' AND ($2 = 0 OR version = $2)
 	`
 
-	res, err := tx.Exec(query, id)
+	res, err := r.db.Executor(ctx).ExecContext(ctx, query, id, version)
 	if err != nil {
-		_ = tx.Rollback()
 		return
 	}
 
 	cnt, err := res.RowsAffected()
 	if err != nil {
-		_ = tx.Rollback()
 		return
 	}
 
 	if cnt == 0 {
-		_ = tx.Rollback()
+		if version != 0 {
+			return r.versionMismatch(ctx, id, StatusActive)
+		}
+
 		return sql.ErrNoRows
 	}
 
-	return tx.Commit()
+	return
+}
+
+// Restore brings a deleted company back to active. Its code must not be
+// in use by another company by then. A non-zero version must match the
+// stored one.
+func (r *repository) Restore(ctx context.Context, id, version int) (restored Company, err error) {
+	ctx, cancel := r.db.Query(ctx, "company.Restore")
+	defer cancel()
+
+	query := `
+		UPDATE companies
+		SET status = 'active', version = version + 1, updated_at = now(), deleted_at = NULL
+		WHERE id = $1 AND status = 'deleted' AND ($2 = 0 OR version = $2)
+		RETURNING id, name, code, country, website, phone, status, version, created_at, updated_at, deleted_at
+	`
+
+	c := &restored
+	err = r.db.Executor(ctx).QueryRowContext(ctx, query, id, version).
+		Scan(&c.ID, &c.Name, &c.Code, &c.Country, &c.Website, &c.Phone, &c.Status, &c.Version, &c.CreatedAt, &c.UpdatedAt, &c.DeletedAt)
+	if err == sql.ErrNoRows && version != 0 {
+		return Company{}, r.versionMismatch(ctx, id, StatusDeleted)
+	}
+
+	return
+}
+
+// Purge hard-deletes up to limit companies that were deleted more than
+// olderThan ago, oldest first, and returns them as they were last
+// stored. Rows another purge is working on are skipped.
+func (r *repository) Purge(ctx context.Context, olderThan time.Duration, limit int) (purged []Company, err error) {
+	ctx, cancel := r.db.Query(ctx, "company.Purge")
+	defer cancel()
+
+	query := `
+		DELETE FROM companies
+		WHERE id IN (
+			SELECT id FROM companies
+			WHERE status = 'deleted' AND deleted_at < now() - make_interval(secs => $1)
+			ORDER BY deleted_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, name, code, country, website, phone, status, version, created_at, updated_at, deleted_at
+	`
+
+	rows, err := r.db.Executor(ctx).QueryContext(ctx, query, olderThan.Seconds(), limit)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c Company
+		err = rows.Scan(&c.ID, &c.Name, &c.Code, &c.Country, &c.Website, &c.Phone, &c.Status, &c.Version, &c.CreatedAt, &c.UpdatedAt, &c.DeletedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		purged = append(purged, c)
+	}
+
+	return purged, rows.Err()
+}
+
+// CountPurgeable counts the companies Purge would delete with olderThan.
+func (r *repository) CountPurgeable(ctx context.Context, olderThan time.Duration) (n int, err error) {
+	ctx, cancel := r.db.Query(ctx, "company.CountPurgeable")
+	defer cancel()
+
+	query := `
+		SELECT count(*) FROM companies
+		WHERE status = 'deleted' AND deleted_at < now() - make_interval(secs => $1)
+	`
+
+	err = r.db.Reader(ctx).QueryRowContext(ctx, query, olderThan.Seconds()).Scan(&n)
+
+	return
 }
@@ -1,218 +1,306 @@
 package company_test
 
 import (
+	"context"
 	"database/sql"
-	"fmt"
 	"testing"
-	"xm/configs"
-	"xm/pkg/db"
-	"xm/pkg/logger"
-	"xm/pkg/repositories"
+	"time"
+	"xm/pkg/db/dbtest"
 	"xm/pkg/repositories/company"
 
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/require"
-	"go.uber.org/fx"
-	"go.uber.org/fx/fxtest"
 )
 
 func TestCreate(t *testing.T) {
-	repo, err := getTestRepo(t)
-	require.NoError(t, err)
+	repo := getTestRepo(t)
 
 	comp := company.Company{
 		Name: "name",
 		Code: "code",
 	}
 
-	err = repo.Create(comp)
+	created, err := repo.Create(context.Background(), comp)
+	require.NoError(t, err)
+	require.Equal(t, 1, created.ID)
+	require.Equal(t, "active", created.Status)
+	require.Equal(t, 1, created.Version)
+
+	_, err = repo.Create(context.Background(), comp)
+	var pqErr *pq.Error
+	require.ErrorAs(t, err, &pqErr)
+	require.Equal(t, "companies_code_key", pqErr.Constraint)
+
+	require.NoError(t, repo.DeleteByID(context.Background(), 1, 0))
+
+	_, err = repo.Create(context.Background(), comp)
 	require.NoError(t, err)
 }
 
 func TestGetByID(t *testing.T) {
-	repo, err := getTestRepo(t)
-	require.NoError(t, err)
+	repo := getTestRepo(t)
 
 	comp := company.Company{
 		Name: "name",
 		Code: "code",
 	}
 
-	err = repo.Create(comp)
+	_, err := repo.Create(context.Background(), comp)
 	require.NoError(t, err)
 
-	c, err := repo.GetByID(1)
+	c, err := repo.GetByID(context.Background(), 1)
 	require.NoError(t, err)
 
 	require.Equal(t, comp.Code, c.Code)
 }
 
 func TestGetAll(t *testing.T) {
-	repo, err := getTestRepo(t)
-	require.NoError(t, err)
+	repo := getTestRepo(t)
 
 	comp := company.Company{
 		Name: "name",
 		Code: "code",
 	}
 
-	err = repo.Create(comp)
+	_, err := repo.Create(context.Background(), comp)
 	require.NoError(t, err)
 
-	err = repo.Create(comp)
+	comp.Code = "code2"
+	_, err = repo.Create(context.Background(), comp)
 	require.NoError(t, err)
 
-	err = repo.Create(company.Company{
+	_, err = repo.Create(context.Background(), company.Company{
 		Name: "other",
-		Code: "code",
+		Code: "code3",
 	})
 	require.NoError(t, err)
 
-	c, err := repo.GetAll(company.Filters{Limit: 10})
+	c, err := repo.GetAll(context.Background(), company.Filters{Limit: 10})
 	require.NoError(t, err)
 
 	require.Equal(t, 3, len(c))
 
-	c, err = repo.GetAll(company.Filters{Name: "other", Limit: 10})
+	c, err = repo.GetAll(context.Background(), company.Filters{Name: "other", Limit: 10})
 	require.NoError(t, err)
 
 	require.Equal(t, 1, len(c))
 
-	c, err = repo.GetAll(company.Filters{Name: "not existing", Limit: 10})
+	c, err = repo.GetAll(context.Background(), company.Filters{Name: "not existing", Limit: 10})
 	require.Equal(t, sql.ErrNoRows, err)
 
 	require.Equal(t, 0, len(c))
 }
 
 func TestUpdate(t *testing.T) {
-	repo, err := getTestRepo(t)
-	require.NoError(t, err)
+	repo := getTestRepo(t)
 
 	c := company.Company{
 		Name: "name1",
 		Code: "ABC",
 	}
-	err = repo.Create(c)
+	_, err := repo.Create(context.Background(), c)
+	require.NoError(t, err)
+
+	created, err := repo.GetByID(context.Background(), 1)
 	require.NoError(t, err)
 
-	err = repo.Update(company.Company{
+	updated, err := repo.Update(context.Background(), company.Company{
 		ID:   1,
 		Code: "EFG",
 	})
 	require.NoError(t, err)
+	require.Equal(t, "name1", updated.Name)
+	require.Equal(t, "EFG", updated.Code)
+	require.Equal(t, 2, updated.Version)
+	require.True(t, updated.UpdatedAt.After(created.UpdatedAt))
+
+	c2, err := repo.GetByID(context.Background(), 1)
+	require.NoError(t, err)
+
+	require.Equal(t, updated, c2)
+
+	_, err = repo.Update(context.Background(), company.Company{
+		ID:      1,
+		Code:    "HIJ",
+		Version: 1,
+	})
+	require.Equal(t, company.ErrVersionMismatch, err)
 
-	c2, err := repo.GetByID(1)
+	updated, err = repo.Update(context.Background(), company.Company{
+		ID:      1,
+		Code:    "HIJ",
+		Version: 2,
+	})
 	require.NoError(t, err)
+	require.Equal(t, 3, updated.Version)
+
+	_, err = repo.Update(context.Background(), company.Company{ID: 2, Code: "KLM"})
+	require.Equal(t, sql.ErrNoRows, err)
+
+	require.NoError(t, repo.DeleteByID(context.Background(), 1, 0))
 
-	require.Equal(t, c2.Code, "EFG")
+	_, err = repo.Update(context.Background(), company.Company{ID: 1, Code: "KLM", Version: 4})
+	require.Equal(t, sql.ErrNoRows, err)
 }
 
-func TestDeleteByID(t *testing.T) {
-	repo, err := getTestRepo(t)
+func TestReplace(t *testing.T) {
+	repo := getTestRepo(t)
+
+	_, err := repo.Create(context.Background(), company.Company{
+		Name:    "name1",
+		Code:    "ABC",
+		Country: "country",
+		Phone:   "phone",
+	})
+	require.NoError(t, err)
+
+	updated, err := repo.Replace(context.Background(), company.Company{
+		ID:      1,
+		Name:    "name2",
+		Code:    "ABC",
+		Version: 1,
+	})
 	require.NoError(t, err)
+	require.Equal(t, "name2", updated.Name)
+	require.Equal(t, "", updated.Country)
+	require.Equal(t, "", updated.Phone)
+	require.Equal(t, 2, updated.Version)
+
+	c, err := repo.GetByID(context.Background(), 1)
+	require.NoError(t, err)
+	require.Equal(t, updated, c)
+
+	_, err = repo.Replace(context.Background(), company.Company{ID: 1, Name: "stale", Code: "ABC", Version: 1})
+	require.Equal(t, company.ErrVersionMismatch, err)
+
+	_, err = repo.Replace(context.Background(), company.Company{ID: 2, Name: "missing", Code: "DEF"})
+	require.Equal(t, sql.ErrNoRows, err)
+}
+
+func TestDeleteByID(t *testing.T) {
+	repo := getTestRepo(t)
 
 	c := company.Company{
 		Name: "name1",
 		Code: "ABC",
 	}
-	err = repo.Create(c)
+	_, err := repo.Create(context.Background(), c)
 	require.NoError(t, err)
 
-	err = repo.DeleteByID(1)
+	err = repo.DeleteByID(context.Background(), 1, 2)
+	require.Equal(t, company.ErrVersionMismatch, err)
+
+	err = repo.DeleteByID(context.Background(), 1, 1)
 	require.NoError(t, err)
 
-	_, err = repo.GetByID(1)
+	_, err = repo.GetByID(context.Background(), 1)
 	require.EqualError(t, err, sql.ErrNoRows.Error())
-}
 
-type mock struct {
-	db *sql.DB
+	err = repo.DeleteByID(context.Background(), 1, 2)
+	require.Equal(t, sql.ErrNoRows, err)
+
+	err = repo.DeleteByID(context.Background(), 99, 1)
+	require.Equal(t, sql.ErrNoRows, err)
 }
 
-func getTestRepo(t *testing.T) (company.Repository, error) {
-	var repo company.Repository
-	var dbConn *sql.DB
-
-	go fxtest.New(
-		fxtest.TB(t),
-		fx.Options(
-			configs.Module,
-			logger.Module,
-
-			fx.Provide(
-				func(
-					p struct {
-						fx.In
-						Configs configs.Configs
-					},
-				) db.Database {
-					dbConn = prepare(p.Configs)
-
-					return &mock{
-						db: dbConn,
-					}
-				},
-			),
-
-			repositories.Module,
-		),
-		fx.Populate(&repo),
-	).Run()
-
-	err := createCompaniesTable(dbConn)
-
-	return repo, err
+func TestRestore(t *testing.T) {
+	repo := getTestRepo(t)
+
+	_, err := repo.Create(context.Background(), company.Company{Name: "name1", Code: "ABC"})
+	require.NoError(t, err)
+	_, err = repo.Create(context.Background(), company.Company{Name: "name2", Code: "DEF"})
+	require.NoError(t, err)
+
+	_, err = repo.Restore(context.Background(), 1, 0)
+	require.Equal(t, sql.ErrNoRows, err)
+	_, err = repo.Restore(context.Background(), 1, 1)
+	require.Equal(t, sql.ErrNoRows, err)
+	_, err = repo.Restore(context.Background(), 99, 1)
+	require.Equal(t, sql.ErrNoRows, err)
+
+	require.NoError(t, repo.DeleteByID(context.Background(), 1, 0))
+	require.NoError(t, repo.DeleteByID(context.Background(), 2, 0))
+
+	deleted, err := repo.GetAll(context.Background(), company.Filters{Status: company.StatusDeleted, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, deleted, 2)
+
+	_, err = repo.Restore(context.Background(), 1, 1)
+	require.Equal(t, company.ErrVersionMismatch, err)
+
+	restored, err := repo.Restore(context.Background(), 1, 2)
+	require.NoError(t, err)
+	require.Equal(t, "active", restored.Status)
+	require.Equal(t, 3, restored.Version)
+	require.Nil(t, restored.DeletedAt)
+
+	c, err := repo.GetByID(context.Background(), 1)
+	require.NoError(t, err)
+	require.Equal(t, restored, c)
+
+	_, err = repo.Create(context.Background(), company.Company{Name: "name3", Code: "DEF"})
+	require.NoError(t, err)
+
+	var pqErr *pq.Error
+	_, err = repo.Restore(context.Background(), 2, 0)
+	require.ErrorAs(t, err, &pqErr)
+	require.Equal(t, "companies_code_key", pqErr.Constraint)
+
+	all, err := repo.GetAll(context.Background(), company.Filters{Status: company.StatusAll, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, all, 3)
 }
 
-func prepare(cfg configs.Configs) *sql.DB {
-	psqlInfo := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=testdb sslmode=disable",
-		"testdb", "5432", cfg.Peek().Database.User, cfg.Peek().Database.Password)
-	db, err := sql.Open("postgres", psqlInfo)
-	if err != nil {
-		panic(err)
-	}
+func TestPurge(t *testing.T) {
+	repo := getTestRepo(t)
 
-	err = db.Ping()
-	if err != nil {
-		panic(err)
+	for _, code := range []string{"A", "B", "C"} {
+		_, err := repo.Create(context.Background(), company.Company{Name: "name", Code: code})
+		require.NoError(t, err)
 	}
 
-	fmt.Println("Successfully connected!")
+	require.NoError(t, repo.DeleteByID(context.Background(), 1, 0))
+	require.NoError(t, repo.DeleteByID(context.Background(), 2, 0))
 
-	return db
-}
+	locked, err := repo.Lock(context.Background(), 1)
+	require.NoError(t, err)
+	require.Equal(t, "deleted", locked.Status)
 
-func (m *mock) Connection() *sql.DB {
-	return m.db
-}
+	_, err = repo.Lock(context.Background(), 4)
+	require.Equal(t, sql.ErrNoRows, err)
 
-func (m *mock) CloseConnection() error {
-	return m.db.Close()
+	deleted, err := repo.GetAll(context.Background(), company.Filters{ID: 1, Status: company.StatusDeleted, Limit: 1})
+	require.NoError(t, err)
+	require.NotNil(t, deleted[0].DeletedAt)
+
+	n, err := repo.CountPurgeable(context.Background(), time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+
+	n, err = repo.CountPurgeable(context.Background(), 0)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+
+	purged, err := repo.Purge(context.Background(), 0, 1)
+	require.NoError(t, err)
+	require.Len(t, purged, 1)
+	require.Equal(t, 1, purged[0].ID)
+	require.Equal(t, company.StatusDeleted, purged[0].Status)
+	require.NotNil(t, purged[0].DeletedAt)
+
+	purged, err = repo.Purge(context.Background(), 0, 10)
+	require.NoError(t, err)
+	require.Len(t, purged, 1)
+	require.Equal(t, 2, purged[0].ID)
+
+	all, err := repo.GetAll(context.Background(), company.Filters{Status: company.StatusAll, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, all, 1)
+	require.Nil(t, all[0].DeletedAt)
 }
 
-func createCompaniesTable(db *sql.DB) (err error) {
-	query := `
-		DROP TABLE IF EXISTS companies;
-	`
-
-	db.Exec(query)
-
-	query = `
-		CREATE TABLE companies(
-			id serial primary key,
-			name varchar(100) not null,
-			code varchar(50) not null,
-			country varchar(20) not null,
-			website varchar(100) not null,
-			phone varchar(50) not null,
-			status varchar(20) not null default 'active',
-			created_at timestamp not null default now(),
-			updated_at timestamp not null default now()
-		);
-	`
-	_, err = db.Exec(query)
-	if err != nil {
-		return
-	}
+func getTestRepo(t *testing.T) company.Repository {
+	t.Parallel()
 
-	return
+	return dbtest.New(t).Companies
 }
@@ -0,0 +1,293 @@
+package company
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type memory struct {
+	mu        sync.RWMutex
+	companies []Company
+}
+
+// NewMemory returns a Repository that keeps companies in process memory
+// and behaves like the postgres one, for dev runs and tests.
+func NewMemory() Repository {
+	return &memory{}
+}
+
+func (m *memory) Create(ctx context.Context, c Company) (created Company, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.codeTaken(c.Code, 0) {
+		return Company{}, errCodeTaken
+	}
+
+	now := time.Now()
+
+	c.ID = len(m.companies) + 1
+	c.Status = "active"
+	c.Version = 1
+	c.CreatedAt = now
+	c.UpdatedAt = now
+
+	m.companies = append(m.companies, c)
+
+	return c, nil
+}
+
+func (m *memory) GetByID(ctx context.Context, id int) (c Company, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	i, ok := m.index(id)
+	if !ok || m.companies[i].Status != "active" {
+		return Company{}, sql.ErrNoRows
+	}
+
+	return m.companies[i], nil
+}
+
+// Lock only reads the company; the memory repository has no
+// transactions to hold a lock for.
+func (m *memory) Lock(ctx context.Context, id int) (c Company, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	i, ok := m.index(id)
+	if !ok || m.companies[i].Status == purged {
+		return Company{}, sql.ErrNoRows
+	}
+
+	return m.companies[i], nil
+}
+
+func (m *memory) GetAll(ctx context.Context, f Filters) (companies []Company, err error) {
+	if f.Limit < 0 {
+		return nil, errors.New("LIMIT must not be negative")
+	}
+
+	if f.Offset < 0 {
+		return nil, errors.New("OFFSET must not be negative")
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	skip := f.Offset
+	for _, c := range m.companies {
+		if len(companies) == f.Limit {
+			break
+		}
+
+		if !f.match(c) {
+			continue
+		}
+
+		if skip > 0 {
+			skip--
+			continue
+		}
+
+		companies = append(companies, c)
+	}
+
+	if len(companies) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	return
+}
+
+func (m *memory) Update(ctx context.Context, c Company) (updated Company, err error) {
+	return m.write(c, func(stored *Company) {
+		set(&stored.Name, c.Name)
+		set(&stored.Code, c.Code)
+		set(&stored.Country, c.Country)
+		set(&stored.Website, c.Website)
+		set(&stored.Phone, c.Phone)
+	})
+}
+
+func (m *memory) Replace(ctx context.Context, c Company) (updated Company, err error) {
+	return m.write(c, func(stored *Company) {
+		stored.Name = c.Name
+		stored.Code = c.Code
+		stored.Country = c.Country
+		stored.Website = c.Website
+		stored.Phone = c.Phone
+	})
+}
+
+// write runs the checks Update and Replace share, then lets apply change
+// the stored company.
+func (m *memory) write(c Company, apply func(stored *Company)) (updated Company, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i, ok := m.index(c.ID)
+	if !ok || m.companies[i].Status != "active" {
+		return Company{}, sql.ErrNoRows
+	}
+
+	if c.Version != 0 && c.Version != m.companies[i].Version {
+		return Company{}, ErrVersionMismatch
+	}
+
+	if c.Code != "" && m.codeTaken(c.Code, c.ID) {
+		return Company{}, errCodeTaken
+	}
+
+	stored := &m.companies[i]
+	apply(stored)
+	stored.Version++
+	stored.UpdatedAt = time.Now()
+
+	return *stored, nil
+}
+
+func (m *memory) DeleteByID(ctx context.Context, id, version int) (err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i, ok := m.index(id)
+	if !ok || m.companies[i].Status != StatusActive {
+		return sql.ErrNoRows
+	}
+
+	if version != 0 && version != m.companies[i].Version {
+		return ErrVersionMismatch
+	}
+
+	now := time.Now()
+
+	m.companies[i].Status = "deleted"
+	m.companies[i].Version++
+	m.companies[i].DeletedAt = &now
+
+	return nil
+}
+
+func (m *memory) Restore(ctx context.Context, id, version int) (restored Company, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i, ok := m.index(id)
+	if !ok || m.companies[i].Status != StatusDeleted {
+		return Company{}, sql.ErrNoRows
+	}
+
+	if version != 0 && version != m.companies[i].Version {
+		return Company{}, ErrVersionMismatch
+	}
+
+	if m.codeTaken(m.companies[i].Code, id) {
+		return Company{}, errCodeTaken
+	}
+
+	stored := &m.companies[i]
+	stored.Status = "active"
+	stored.Version++
+	stored.UpdatedAt = time.Now()
+	stored.DeletedAt = nil
+
+	return *stored, nil
+}
+
+// purged is the status Purge leaves behind instead of removing the
+// company, so the positions index relies on stay put.
+const purged = "purged"
+
+func (m *memory) Purge(ctx context.Context, olderThan time.Duration, limit int) (companies []Company, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, i := range m.purgeable(olderThan) {
+		if len(companies) == limit {
+			break
+		}
+
+		companies = append(companies, m.companies[i])
+		m.companies[i] = Company{ID: m.companies[i].ID, Status: purged}
+	}
+
+	return companies, nil
+}
+
+func (m *memory) CountPurgeable(ctx context.Context, olderThan time.Duration) (n int, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return len(m.purgeable(olderThan)), nil
+}
+
+// purgeable returns the positions of the companies deleted more than
+// olderThan ago, oldest deletion first.
+func (m *memory) purgeable(olderThan time.Duration) []int {
+	var out []int
+	for i, c := range m.companies {
+		if c.Status == "deleted" && time.Since(*c.DeletedAt) > olderThan {
+			out = append(out, i)
+		}
+	}
+
+	sort.SliceStable(out, func(a, b int) bool {
+		return m.companies[out[a]].DeletedAt.Before(*m.companies[out[b]].DeletedAt)
+	})
+
+	return out
+}
+
+// errCodeTaken is what postgres reports for the companies_code_key index.
+var errCodeTaken = &pq.Error{
+	Code:       "23505",
+	Message:    `duplicate key value violates unique constraint "companies_code_key"`,
+	Table:      "companies",
+	Constraint: "companies_code_key",
+}
+
+// codeTaken reports whether another active company uses code.
+func (m *memory) codeTaken(code string, id int) bool {
+	for _, c := range m.companies {
+		if c.ID != id && c.Code == code && c.Status == "active" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// index finds id; ids are handed out in order and never reused, so
+// company n sits at n-1.
+func (m *memory) index(id int) (int, bool) {
+	if id < 1 || id > len(m.companies) {
+		return 0, false
+	}
+
+	return id - 1, true
+}
+
+func (f Filters) match(c Company) bool {
+	return (f.status() == c.Status || (f.status() == StatusAll && c.Status != purged)) &&
+		(f.ID == 0 || f.ID == c.ID) &&
+		(f.Name == "" || f.Name == c.Name) &&
+		(f.Code == "" || f.Code == c.Code) &&
+		(f.Country == "" || f.Country == c.Country) &&
+		(f.Website == "" || f.Website == c.Website) &&
+		(f.Phone == "" || f.Phone == c.Phone)
+}
+
+// set mirrors the COALESCE(NULLIF(...)) in the postgres Update: empty
+// values keep the stored one.
+func set(field *string, value string) {
+	if value != "" {
+		*field = value
+	}
+}
@@ -0,0 +1,154 @@
+package company_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+	"xm/pkg/repositories/company"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemory(t *testing.T) {
+	ctx := context.Background()
+	repo := company.NewMemory()
+
+	for i, name := range []string{"a", "b", "b"} {
+		created, err := repo.Create(ctx, company.Company{Name: name, Code: fmt.Sprint("code", i), Country: "CY"})
+		require.NoError(t, err)
+		require.Equal(t, i+1, created.ID)
+	}
+
+	var pqErr *pq.Error
+	_, err := repo.Create(ctx, company.Company{Name: "c", Code: "code0"})
+	require.ErrorAs(t, err, &pqErr)
+	require.Equal(t, "companies_code_key", pqErr.Constraint)
+	_, err = repo.Update(ctx, company.Company{ID: 2, Code: "code0"})
+	require.ErrorAs(t, err, &pqErr)
+
+	c, err := repo.GetByID(ctx, 2)
+	require.NoError(t, err)
+	require.Equal(t, "b", c.Name)
+	require.Equal(t, "active", c.Status)
+
+	cs, err := repo.GetAll(ctx, company.Filters{Name: "b", Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, cs, 2)
+
+	cs, err = repo.GetAll(ctx, company.Filters{Country: "CY", Limit: 1, Offset: 1})
+	require.NoError(t, err)
+	require.Equal(t, 2, cs[0].ID)
+
+	_, err = repo.GetAll(ctx, company.Filters{Country: "CY"})
+	require.Equal(t, sql.ErrNoRows, err)
+
+	_, err = repo.GetAll(ctx, company.Filters{Limit: -1})
+	require.Error(t, err)
+
+	updated, err := repo.Update(ctx, company.Company{ID: 1, Name: "renamed"})
+	require.NoError(t, err)
+	require.Equal(t, 2, updated.Version)
+	require.Equal(t, "code0", updated.Code)
+
+	c, err = repo.GetByID(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, "renamed", c.Name)
+	require.Equal(t, "code0", c.Code)
+	require.Equal(t, 2, c.Version)
+
+	_, err = repo.Update(ctx, company.Company{ID: 1, Name: "stale", Version: 1})
+	require.Equal(t, company.ErrVersionMismatch, err)
+	require.Equal(t, company.ErrVersionMismatch, repo.DeleteByID(ctx, 1, 1))
+
+	replaced, err := repo.Replace(ctx, company.Company{ID: 3, Name: "b", Code: "code2", Version: 1})
+	require.NoError(t, err)
+	require.Equal(t, "", replaced.Country)
+	require.Equal(t, 2, replaced.Version)
+	_, err = repo.Replace(ctx, company.Company{ID: 3, Name: "b", Code: "code1"})
+	require.ErrorAs(t, err, &pqErr)
+	_, err = repo.Replace(ctx, company.Company{ID: 3, Name: "stale", Version: 1})
+	require.Equal(t, company.ErrVersionMismatch, err)
+
+	require.NoError(t, repo.DeleteByID(ctx, 1, 2))
+	require.Equal(t, sql.ErrNoRows, repo.DeleteByID(ctx, 1, 0))
+	require.Equal(t, sql.ErrNoRows, repo.DeleteByID(ctx, 99, 0))
+	require.Equal(t, sql.ErrNoRows, repo.DeleteByID(ctx, 1, 3))
+	require.Equal(t, sql.ErrNoRows, repo.DeleteByID(ctx, 99, 1))
+
+	_, err = repo.Update(ctx, company.Company{ID: 1, Name: "deleted"})
+	require.Equal(t, sql.ErrNoRows, err)
+	_, err = repo.Update(ctx, company.Company{ID: 99, Name: "missing"})
+	require.Equal(t, sql.ErrNoRows, err)
+
+	_, err = repo.GetByID(ctx, 1)
+	require.Equal(t, sql.ErrNoRows, err)
+
+	cs, err = repo.GetAll(ctx, company.Filters{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, cs, 2)
+
+	cs, err = repo.GetAll(ctx, company.Filters{Status: company.StatusDeleted, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, cs, 1)
+	require.Equal(t, 1, cs[0].ID)
+
+	cs, err = repo.GetAll(ctx, company.Filters{Status: company.StatusAll, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, cs, 3)
+
+	_, err = repo.Create(ctx, company.Company{Name: "again", Code: "code0"})
+	require.NoError(t, err)
+
+	locked, err := repo.Lock(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, "deleted", locked.Status)
+
+	_, err = repo.Restore(ctx, 1, 0)
+	require.ErrorAs(t, err, &pqErr)
+	_, err = repo.Restore(ctx, 2, 0)
+	require.Equal(t, sql.ErrNoRows, err)
+	_, err = repo.Restore(ctx, 2, 1)
+	require.Equal(t, sql.ErrNoRows, err)
+	_, err = repo.Restore(ctx, 99, 1)
+	require.Equal(t, sql.ErrNoRows, err)
+	_, err = repo.Restore(ctx, 1, 1)
+	require.Equal(t, company.ErrVersionMismatch, err)
+
+	require.NoError(t, repo.DeleteByID(ctx, 4, 0))
+
+	restored, err := repo.Restore(ctx, 1, 3)
+	require.NoError(t, err)
+	require.Equal(t, "active", restored.Status)
+	require.Equal(t, 4, restored.Version)
+	require.Nil(t, restored.DeletedAt)
+
+	require.NoError(t, repo.DeleteByID(ctx, 2, 0))
+
+	n, err := repo.CountPurgeable(ctx, time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+
+	n, err = repo.CountPurgeable(ctx, 0)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+
+	purged, err := repo.Purge(ctx, 0, 1)
+	require.NoError(t, err)
+	require.Len(t, purged, 1)
+	require.Equal(t, 4, purged[0].ID)
+	require.Equal(t, company.StatusDeleted, purged[0].Status)
+	require.NotNil(t, purged[0].DeletedAt)
+
+	purged, err = repo.Purge(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, purged, 1)
+	require.Equal(t, 2, purged[0].ID)
+
+	cs, err = repo.GetAll(ctx, company.Filters{Status: company.StatusAll, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, cs, 2)
+	require.Equal(t, sql.ErrNoRows, repo.DeleteByID(ctx, 2, 0))
+}
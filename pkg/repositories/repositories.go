@@ -1,6 +1,8 @@
 package repositories
 
 import (
+	"xm/pkg/db"
+	"xm/pkg/repositories/audit"
 	"xm/pkg/repositories/company"
 	"xm/pkg/repositories/user"
 
@@ -10,4 +12,14 @@ import (
 var Module = fx.Options(
 	user.Module,
 	company.Module,
+	audit.Module,
+)
+
+// Memory provides the in-memory repositories, which need no database,
+// and a TxManager that runs units of work without a transaction.
+var Memory = fx.Options(
+	fx.Provide(user.NewMemory),
+	fx.Provide(company.NewMemory),
+	fx.Provide(audit.NewMemory),
+	fx.Provide(db.NopTxManager),
 )
@@ -0,0 +1,79 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type memory struct {
+	mu     sync.RWMutex
+	nextID int
+	users  map[string]User
+}
+
+// NewMemory returns a Repository that keeps users in process memory
+// and behaves like the postgres one, for dev runs and tests.
+func NewMemory() Repository {
+	return &memory{
+		users: make(map[string]User),
+	}
+}
+
+// Create reports a taken username the way postgres does, as a
+// unique_violation, so callers handle both repositories alike.
+func (m *memory) Create(ctx context.Context, u *User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[u.Username]; ok {
+		return &pq.Error{
+			Code:       "23505",
+			Message:    `duplicate key value violates unique constraint "users_username_key"`,
+			Table:      "users",
+			Constraint: "users_username_key",
+		}
+	}
+
+	now := time.Now()
+
+	m.nextID++
+	u.ID = m.nextID
+	u.CreatedAt = now
+	u.UpdatedAt = now
+
+	m.users[u.Username] = *u
+
+	return nil
+}
+
+func (m *memory) GetByUsername(ctx context.Context, username string) (*User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	u, ok := m.users[username]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	return &u, nil
+}
+
+func (m *memory) UpdatePassword(ctx context.Context, username, password string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[username]
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	u.Password = password
+	u.UpdatedAt = time.Now()
+	m.users[username] = u
+
+	return nil
+}
@@ -0,0 +1,34 @@
+package user_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"xm/pkg/repositories/user"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemory(t *testing.T) {
+	ctx := context.Background()
+	repo := user.NewMemory()
+
+	u := user.User{Username: "some", Password: "pass"}
+	require.NoError(t, repo.Create(ctx, &u))
+	require.Equal(t, 1, u.ID)
+
+	err := repo.Create(ctx, &user.User{Username: "some", Password: "other"})
+	var pqErr *pq.Error
+	require.ErrorAs(t, err, &pqErr)
+	require.Equal(t, pq.ErrorCode("23505"), pqErr.Code)
+
+	require.NoError(t, repo.UpdatePassword(ctx, "some", "new"))
+	got, err := repo.GetByUsername(ctx, "some")
+	require.NoError(t, err)
+	require.Equal(t, "new", got.Password)
+
+	_, err = repo.GetByUsername(ctx, "missing")
+	require.Equal(t, sql.ErrNoRows, err)
+	require.Equal(t, sql.ErrNoRows, repo.UpdatePassword(ctx, "missing", "new"))
+}
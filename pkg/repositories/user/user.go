@@ -1,6 +1,7 @@
 package user
 
 import (
+	"context"
 	"database/sql"
 	"time"
 	"xm/pkg/db"
@@ -11,12 +12,13 @@ import (
 var Module = fx.Provide(New)
 
 type Repository interface {
-	Create(u *User) error
-	GetByUsername(username string) (*User, error)
+	Create(ctx context.Context, u *User) error
+	GetByUsername(ctx context.Context, username string) (*User, error)
+	UpdatePassword(ctx context.Context, username, password string) error
 }
 
 type repository struct {
-	db *sql.DB
+	db db.Database
 }
 
 type Params struct {
@@ -26,7 +28,7 @@ type Params struct {
 
 func New(p Params) Repository {
 	return &repository{
-		db: p.DB.Connection(),
+		db: p.DB,
 	}
 }
 
@@ -38,7 +40,9 @@ type User struct {
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
-func (r *repository) Create(u *User) error {
+func (r *repository) Create(ctx context.Context, u *User) error {
+	ctx, cancel := r.db.Query(ctx, "user.Create")
+	defer cancel()
 
 	query := `
 		INSERT INTO users (
@@ -49,7 +53,7 @@ func (r *repository) Create(u *User) error {
 		RETURNING id, created_at, updated_at
 	`
 
-	err := r.db.QueryRow(query, u.Username, u.Password).Scan(&u.ID, &u.CreatedAt, &u.UpdatedAt)
+	err := r.db.Executor(ctx).QueryRowContext(ctx, query, u.Username, db.Secret(u.Password)).Scan(&u.ID, &u.CreatedAt, &u.UpdatedAt)
 	if err != nil {
 		return err
 	}
@@ -57,7 +61,10 @@ func (r *repository) Create(u *User) error {
 	return nil
 }
 
-func (r *repository) GetByUsername(username string) (*User, error) {
+func (r *repository) GetByUsername(ctx context.Context, username string) (*User, error) {
+	ctx, cancel := r.db.Query(ctx, "user.GetByUsername")
+	defer cancel()
+
 	var u User
 
 	query := `
@@ -66,7 +73,7 @@ func (r *repository) GetByUsername(username string) (*User, error) {
 		WHERE username = $1
 	`
 
-	err := r.db.QueryRow(query, username).Scan(&u.ID, &u.Username, &u.Password,
+	err := r.db.Reader(ctx).QueryRowContext(ctx, query, username).Scan(&u.ID, &u.Username, &u.Password,
 		&u.CreatedAt, &u.UpdatedAt)
 	if err != nil {
 		return nil, err
@@ -74,3 +81,30 @@ func (r *repository) GetByUsername(username string) (*User, error) {
 
 	return &u, nil
 }
+
+func (r *repository) UpdatePassword(ctx context.Context, username, password string) error {
+	ctx, cancel := r.db.Query(ctx, "user.UpdatePassword")
+	defer cancel()
+
+	query := `
+		UPDATE users
+		SET password = $1, updated_at = now()
+		WHERE username = $2
+	`
+
+	res, err := r.db.Executor(ctx).ExecContext(ctx, query, db.Secret(password), username)
+	if err != nil {
+		return err
+	}
+
+	cnt, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if cnt == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
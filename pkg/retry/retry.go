@@ -0,0 +1,141 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+	"xm/configs"
+)
+
+// Policy describes an exponential backoff: the wait starts at
+// InitialInterval, grows by Multiplier up to MaxInterval and is spread
+// by +/- Jitter (a fraction of the wait). Retrying stops once
+// MaxElapsedTime has passed; zero retries until ctx is done.
+type Policy struct {
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	Multiplier      float64
+	Jitter          float64
+	MaxElapsedTime  time.Duration
+}
+
+func FromConfig(c configs.Retry) Policy {
+	return Policy{
+		InitialInterval: c.InitialInterval.Duration,
+		MaxInterval:     c.MaxInterval.Duration,
+		Multiplier:      c.Multiplier,
+		Jitter:          c.Jitter,
+		MaxElapsedTime:  c.MaxElapsedTime.Duration,
+	}
+}
+
+type permanent struct {
+	err error
+}
+
+func (p *permanent) Error() string {
+	return p.err.Error()
+}
+
+func (p *permanent) Unwrap() error {
+	return p.err
+}
+
+// Permanent marks err as not worth retrying; Do returns it unwrapped
+// straight away.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &permanent{err: err}
+}
+
+// stopped is returned when ctx is done while waiting. It unwraps to
+// ctx.Err(), so callers can tell a canceled request from a failure, and
+// still matches fn's last error with errors.Is and errors.As.
+type stopped struct {
+	attempts int
+	ctxErr   error
+	err      error
+}
+
+func (s *stopped) Error() string {
+	return fmt.Sprintf("gave up after %d attempts: %v: %v", s.attempts, s.ctxErr, s.err)
+}
+
+func (s *stopped) Unwrap() error {
+	return s.ctxErr
+}
+
+func (s *stopped) Is(target error) bool {
+	return errors.Is(s.err, target)
+}
+
+func (s *stopped) As(target interface{}) bool {
+	return errors.As(s.err, target)
+}
+
+// Do calls fn until it succeeds or the policy gives up, calling onRetry
+// before every wait. The error returned after giving up wraps fn's last error.
+func Do(ctx context.Context, p Policy, onRetry func(attempt int, wait time.Duration, err error), fn func(ctx context.Context) error) error {
+	start := time.Now()
+	interval := p.InitialInterval
+
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+
+		var perm *permanent
+		if errors.As(err, &perm) {
+			return perm.err
+		}
+
+		wait := jitter(interval, p.Jitter)
+
+		elapsed := time.Since(start)
+		if p.MaxElapsedTime > 0 && elapsed+wait > p.MaxElapsedTime {
+			return fmt.Errorf("gave up after %d attempts in %s: %w", attempt, elapsed.Round(time.Millisecond), err)
+		}
+
+		if onRetry != nil {
+			onRetry(attempt, wait, err)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return &stopped{attempts: attempt, ctxErr: ctx.Err(), err: err}
+		case <-timer.C:
+		}
+
+		interval = next(interval, p)
+	}
+}
+
+func next(interval time.Duration, p Policy) time.Duration {
+	if p.Multiplier > 1 {
+		interval = time.Duration(float64(interval) * p.Multiplier)
+	}
+
+	if p.MaxInterval > 0 && interval > p.MaxInterval {
+		interval = p.MaxInterval
+	}
+
+	return interval
+}
+
+func jitter(interval time.Duration, fraction float64) time.Duration {
+	if fraction <= 0 {
+		return interval
+	}
+
+	delta := fraction * float64(interval)
+
+	return time.Duration(float64(interval) - delta + rand.Float64()*2*delta)
+}
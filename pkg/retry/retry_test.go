@@ -0,0 +1,76 @@
+package retry_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"xm/pkg/retry"
+
+	"github.com/stretchr/testify/require"
+)
+
+var errDown = errors.New("down")
+
+func TestDoSucceeds(t *testing.T) {
+	var calls, retries int
+
+	err := retry.Do(context.Background(),
+		retry.Policy{InitialInterval: time.Millisecond, Multiplier: 2, MaxElapsedTime: time.Second},
+		func(attempt int, wait time.Duration, err error) { retries++ },
+		func(ctx context.Context) error {
+			calls++
+			if calls < 3 {
+				return errDown
+			}
+			return nil
+		},
+	)
+
+	require.NoError(t, err)
+	require.Equal(t, 3, calls)
+	require.Equal(t, 2, retries)
+}
+
+func TestDoGivesUp(t *testing.T) {
+	start := time.Now()
+
+	err := retry.Do(context.Background(),
+		retry.Policy{InitialInterval: 5 * time.Millisecond, MaxInterval: 10 * time.Millisecond, Multiplier: 2, Jitter: 0.5, MaxElapsedTime: 50 * time.Millisecond},
+		nil,
+		func(ctx context.Context) error { return errDown },
+	)
+
+	require.ErrorIs(t, err, errDown)
+	require.Less(t, time.Since(start), time.Second)
+}
+
+func TestDoPermanent(t *testing.T) {
+	var calls int
+
+	err := retry.Do(context.Background(),
+		retry.Policy{InitialInterval: time.Millisecond, MaxElapsedTime: time.Second},
+		nil,
+		func(ctx context.Context) error {
+			calls++
+			return retry.Permanent(errDown)
+		},
+	)
+
+	require.Equal(t, errDown, err)
+	require.Equal(t, 1, calls)
+}
+
+func TestDoStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	err := retry.Do(ctx,
+		retry.Policy{InitialInterval: 5 * time.Millisecond},
+		nil,
+		func(ctx context.Context) error { return errDown },
+	)
+
+	require.ErrorIs(t, err, errDown)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
@@ -1,97 +1,339 @@
 package company
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"strconv"
+	"time"
 	"xm/gateways/nats"
+	"xm/pkg/db"
+	"xm/pkg/repositories/audit"
 	"xm/pkg/repositories/company"
 	"xm/pkg/services/utils"
 
+	"github.com/lib/pq"
 	"go.uber.org/fx"
 )
 
 var Module = fx.Provide(New)
 
+// Jobs runs the background work of the service, see SchedulePurge.
+var Jobs = fx.Invoke(SchedulePurge)
+
 type Service interface {
-	Create(c company.Company) (err error)
-	GetByID(id int) (c company.Company, err error)
-	GetAll(f company.Filters) (companies []company.Company, err error)
-	Update(c company.Company) (err error)
-	DeleteByID(id int) (err error)
+	Create(ctx context.Context, c company.Company) (err error)
+	GetByID(ctx context.Context, id int) (c company.Company, err error)
+	GetAll(ctx context.Context, f company.Filters) (companies []company.Company, err error)
+	Update(ctx context.Context, c company.Company) (updated company.Company, err error)
+	Replace(ctx context.Context, c company.Company) (updated company.Company, err error)
+	Patch(ctx context.Context, id, version int, fn func(current company.Company) (company.Company, error)) (updated company.Company, err error)
+	DeleteByID(ctx context.Context, id, version int) (err error)
+	Restore(ctx context.Context, id, version int) (restored company.Company, err error)
+	Purge(ctx context.Context, olderThan time.Duration, batchSize int, dryRun bool) (n int, err error)
+	History(ctx context.Context, id, limit, offset int) (entries []audit.Entry, err error)
+}
+
+// uniqueFields names the field behind each unique constraint on companies.
+var uniqueFields = map[string]string{
+	"companies_code_key": "code",
 }
 
 type service struct {
 	companyRepository company.Repository
+	auditRepository   audit.Repository
+	txManager         db.TxManager
 	natsGateway       nats.Gateway
 }
 
 type Params struct {
 	fx.In
 	CompanyRepository company.Repository
+	AuditRepository   audit.Repository
+	TxManager         db.TxManager
 	NATSGateway       nats.Gateway
 }
 
 func New(p Params) Service {
 	return &service{
 		companyRepository: p.CompanyRepository,
+		auditRepository:   p.AuditRepository,
+		txManager:         p.TxManager,
 		natsGateway:       p.NATSGateway,
 	}
 }
 
-func (s *service) Create(c company.Company) (err error) {
-	return s.companyRepository.Create(c)
+func (s *service) Create(ctx context.Context, c company.Company) (err error) {
+	err = s.txManager.Do(ctx, nil, func(ctx context.Context) error {
+		created, err := s.companyRepository.Create(ctx, c)
+		if err != nil {
+			return err
+		}
+
+		return s.record(ctx, audit.ActionCreate, created.ID, nil, &created)
+	})
+	if err != nil {
+		return mapError(ctx, err)
+	}
+
+	return
 }
 
-func (s *service) GetByID(id int) (c company.Company, err error) {
-	c, err = s.companyRepository.GetByID(id)
+func (s *service) GetByID(ctx context.Context, id int) (c company.Company, err error) {
+	c, err = s.companyRepository.GetByID(ctx, id)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return company.Company{}, utils.ErrNotFound
 		}
 
-		return
+		return company.Company{}, utils.ContextError(ctx, err)
 	}
 
 	return
 }
 
-func (s *service) GetAll(f company.Filters) (companies []company.Company, err error) {
-	companies, err = s.companyRepository.GetAll(f)
+func (s *service) GetAll(ctx context.Context, f company.Filters) (companies []company.Company, err error) {
+	companies, err = s.companyRepository.GetAll(ctx, f)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, utils.ErrNotFound
 		}
 
-		return
+		return nil, utils.ContextError(ctx, err)
 	}
 
 	return
 }
 
-func (s *service) Update(c company.Company) (err error) {
-	err = s.companyRepository.Update(c)
+// Update applies c and publishes the company as stored afterwards. A
+// non-zero c.Version must match the stored one.
+func (s *service) Update(ctx context.Context, c company.Company) (updated company.Company, err error) {
+	updated, err = s.change(ctx, audit.ActionUpdate, c.ID, func(ctx context.Context, _ company.Company) (company.Company, error) {
+		return s.companyRepository.Update(ctx, c)
+	})
 	if err != nil {
-		return
+		return company.Company{}, err
+	}
+
+	s.publishUpdate(updated)
+
+	return
+}
+
+// Replace overwrites every editable field with those of c, so empty
+// values clear fields, and publishes the result like Update.
+func (s *service) Replace(ctx context.Context, c company.Company) (updated company.Company, err error) {
+	updated, err = s.change(ctx, audit.ActionReplace, c.ID, func(ctx context.Context, _ company.Company) (company.Company, error) {
+		return s.companyRepository.Replace(ctx, c)
+	})
+	if err != nil {
+		return company.Company{}, err
+	}
+
+	s.publishUpdate(updated)
+
+	return
+}
+
+// Patch replaces the company with what fn makes of it. The company is read
+// under the lock taken for the write, so nothing can change it in between.
+// A non-zero version must match the stored one. Errors from fn come back
+// as they are.
+func (s *service) Patch(ctx context.Context, id, version int, fn func(current company.Company) (company.Company, error)) (updated company.Company, err error) {
+	updated, err = s.change(ctx, audit.ActionUpdate, id, func(ctx context.Context, before company.Company) (company.Company, error) {
+		if before.Status != company.StatusActive {
+			return company.Company{}, sql.ErrNoRows
+		}
+
+		if version != 0 && version != before.Version {
+			return company.Company{}, company.ErrVersionMismatch
+		}
+
+		c, err := fn(before)
+		if err != nil {
+			return company.Company{}, err
+		}
+
+		c.ID = id
+		c.Version = before.Version
+
+		return s.companyRepository.Replace(ctx, c)
+	})
+	if err != nil {
+		return company.Company{}, err
 	}
 
+	s.publishUpdate(updated)
+
+	return
+}
+
+func (s *service) publishUpdate(c company.Company) {
 	m, _ := json.Marshal(c)
 	s.natsGateway.GetConnection().Publish("company_update", m)
+}
+
+func (s *service) DeleteByID(ctx context.Context, id, version int) (err error) {
+	_, err = s.change(ctx, audit.ActionDelete, id, func(ctx context.Context, _ company.Company) (company.Company, error) {
+		err := s.companyRepository.DeleteByID(ctx, id, version)
+		if err != nil {
+			return company.Company{}, err
+		}
+
+		return s.companyRepository.Lock(ctx, id)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.natsGateway.GetConnection().Publish("company_delete", []byte(strconv.Itoa(id)))
 
 	return
 }
 
-func (s *service) DeleteByID(id int) (err error) {
-	err = s.companyRepository.DeleteByID(id)
+// Restore brings a deleted company back and publishes it. It fails with
+// a ConflictError when its code has been taken in the meantime.
+func (s *service) Restore(ctx context.Context, id, version int) (restored company.Company, err error) {
+	restored, err = s.change(ctx, audit.ActionRestore, id, func(ctx context.Context, _ company.Company) (company.Company, error) {
+		return s.companyRepository.Restore(ctx, id, version)
+	})
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return utils.ErrNotFound
+		return company.Company{}, err
+	}
+
+	m, _ := json.Marshal(restored)
+	s.natsGateway.GetConnection().Publish("company_restore", m)
+
+	return
+}
+
+// Purge hard-deletes the companies deleted more than olderThan ago,
+// batchSize per transaction, and publishes company_purged for each. With
+// dryRun it only counts them. n is what was purged before any error.
+func (s *service) Purge(ctx context.Context, olderThan time.Duration, batchSize int, dryRun bool) (n int, err error) {
+	if dryRun {
+		n, err = s.companyRepository.CountPurgeable(ctx, olderThan)
+		if err != nil {
+			return 0, utils.ContextError(ctx, err)
 		}
 
 		return
 	}
 
-	s.natsGateway.GetConnection().Publish("company_delete", []byte(strconv.Itoa(id)))
+	for {
+		var purged []company.Company
+
+		err := s.txManager.Do(ctx, nil, func(ctx context.Context) (err error) {
+			purged, err = s.companyRepository.Purge(ctx, olderThan, batchSize)
+			if err != nil {
+				return err
+			}
+
+			// The entry keeps the last state, as nothing else is left of
+			// the company.
+			for i := range purged {
+				err = s.record(ctx, audit.ActionPurge, purged[i].ID, &purged[i], nil)
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return n, utils.ContextError(ctx, err)
+		}
+
+		for _, c := range purged {
+			s.natsGateway.GetConnection().Publish("company_purged", []byte(strconv.Itoa(c.ID)))
+		}
+
+		n += len(purged)
+		if len(purged) < batchSize {
+			return n, nil
+		}
+	}
+}
+
+// History pages through the audit trail of a company, oldest first.
+func (s *service) History(ctx context.Context, id, limit, offset int) (entries []audit.Entry, err error) {
+	entries, err = s.auditRepository.GetByCompanyID(ctx, id, limit, offset)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, utils.ErrNotFound
+		}
+
+		return nil, utils.ContextError(ctx, err)
+	}
 
 	return
 }
+
+// change runs fn on the company as locked beforehand, in a transaction
+// together with the audit entry for it, the company before and as fn
+// returns it. Errors come back mapped for the caller.
+func (s *service) change(ctx context.Context, action string, id int, fn func(ctx context.Context, before company.Company) (company.Company, error)) (after company.Company, err error) {
+	err = s.txManager.Do(ctx, nil, func(ctx context.Context) error {
+		before, err := s.companyRepository.Lock(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		after, err = fn(ctx, before)
+		if err != nil {
+			return err
+		}
+
+		return s.record(ctx, action, id, &before, &after)
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return company.Company{}, utils.ErrNotFound
+		}
+
+		return company.Company{}, mapError(ctx, err)
+	}
+
+	return
+}
+
+// record writes the audit entry for a change made by the actor on ctx.
+func (s *service) record(ctx context.Context, action string, id int, before, after *company.Company) error {
+	e := audit.Entry{
+		CompanyID: id,
+		ActorID:   utils.Actor(ctx),
+		Action:    action,
+		RequestID: utils.RequestID(ctx),
+	}
+
+	if before != nil {
+		e.Before, _ = json.Marshal(before)
+	}
+
+	if after != nil {
+		e.After, _ = json.Marshal(after)
+	}
+
+	return s.auditRepository.Create(ctx, e)
+}
+
+// mapError turns unique violations into a ConflictError naming the field
+// and version mismatches into ErrPreconditionFailed.
+func mapError(ctx context.Context, err error) error {
+	if errors.Is(err, company.ErrVersionMismatch) {
+		return utils.ErrPreconditionFailed
+	}
+
+	var v *pq.Error
+	if errors.As(err, &v) && v.Code == "23505" {
+		field, known := uniqueFields[v.Constraint]
+		if !known {
+			return utils.ErrAlreadyExists
+		}
+
+		return &utils.ConflictError{Field: field}
+	}
+
+	return utils.ContextError(ctx, err)
+}
@@ -1,17 +1,23 @@
 package company_test
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"testing"
+	"time"
 	"xm/configs"
 	"xm/gateways"
+	"xm/pkg/db"
 	"xm/pkg/logger"
 	"xm/pkg/services"
 	"xm/pkg/services/company"
 	"xm/pkg/services/utils"
 
+	"xm/pkg/repositories/audit"
 	companyRepo "xm/pkg/repositories/company"
 
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/fx"
@@ -19,7 +25,7 @@ import (
 )
 
 func TestCreate(t *testing.T) {
-	svc, m := getTestService(t)
+	svc, m, auditRepo := getTestService(t)
 
 	cmp := companyRepo.Company{
 		Name:    "name",
@@ -27,77 +33,272 @@ func TestCreate(t *testing.T) {
 		Country: "country",
 	}
 
-	m.On("Create", cmp).Return(nil)
+	created := cmp
+	created.ID = 1
+	m.On("Create", cmp).Return(created, nil).Once()
 
-	err := svc.Create(cmp)
+	ctx := utils.WithRequestID(utils.WithActor(context.Background(), 7), "req1")
+	err := svc.Create(ctx, cmp)
 	require.NoError(t, err)
+
+	history, err := auditRepo.GetByCompanyID(context.Background(), 1, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	require.Equal(t, audit.ActionCreate, history[0].Action)
+	require.Equal(t, 7, history[0].ActorID)
+	require.Equal(t, "req1", history[0].RequestID)
+	require.Empty(t, history[0].Before)
+	require.Contains(t, string(history[0].After), `"code":"code"`)
+
+	m.On("Create", cmp).Return(companyRepo.Company{}, &pq.Error{Code: "23505", Constraint: "companies_code_key"})
+
+	err = svc.Create(context.Background(), cmp)
+	require.ErrorIs(t, err, utils.ErrAlreadyExists)
+	require.EqualError(t, err, "code already exists")
 }
 
 func TestGetByID(t *testing.T) {
-	svc, m := getTestService(t)
+	svc, m, _ := getTestService(t)
 
 	m.On("GetByID", 1).Return(companyRepo.Company{Name: "some company"}, nil)
 
-	c, err := svc.GetByID(1)
+	c, err := svc.GetByID(context.Background(), 1)
 	require.NoError(t, err)
 	require.Equal(t, c.Name, "some company")
 
 	m.On("GetByID", 2).Return(companyRepo.Company{}, sql.ErrNoRows)
 
-	c, err = svc.GetByID(2)
+	c, err = svc.GetByID(context.Background(), 2)
 	require.ErrorIs(t, err, utils.ErrNotFound)
 	require.Zero(t, c)
 }
 
 func TestGetAll(t *testing.T) {
-	svc, m := getTestService(t)
+	svc, m, _ := getTestService(t)
 
 	f := companyRepo.Filters{}
 
 	m.On("GetAll", f).Return([]companyRepo.Company{{}, {}}, nil).Once()
 
-	cs, err := svc.GetAll(f)
+	cs, err := svc.GetAll(context.Background(), f)
 	require.NoError(t, err)
 	require.Equal(t, len(cs), 2)
 
 	m.On("GetAll", f).Return(nil, sql.ErrNoRows)
 
-	cs, err = svc.GetAll(f)
+	cs, err = svc.GetAll(context.Background(), f)
 	require.ErrorIs(t, err, utils.ErrNotFound)
 	require.Nil(t, cs)
 }
 
 func TestUpdate(t *testing.T) {
-	svc, m := getTestService(t)
+	svc, m, auditRepo := getTestService(t)
+
+	c := companyRepo.Company{ID: 1, Name: "new"}
+
+	m.On("Lock", 1).Return(companyRepo.Company{ID: 1, Name: "old", Code: "code", Version: 1}, nil)
+	m.On("Lock", 2).Return(companyRepo.Company{}, sql.ErrNoRows)
+	m.On("Update", c).Return(companyRepo.Company{ID: 1, Name: "new", Code: "code", Version: 2}, nil).Once()
+
+	updated, err := svc.Update(utils.WithActor(context.Background(), 7), c)
+	require.NoError(t, err)
+	require.Equal(t, "code", updated.Code)
+	require.Equal(t, 2, updated.Version)
+
+	history, err := auditRepo.GetByCompanyID(context.Background(), 1, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	require.Equal(t, audit.ActionUpdate, history[0].Action)
+	require.Contains(t, string(history[0].Before), `"name":"old"`)
+	require.Contains(t, string(history[0].After), `"name":"new"`)
+
+	c.Version = 1
+	m.On("Update", c).Return(companyRepo.Company{}, companyRepo.ErrVersionMismatch).Once()
+
+	_, err = svc.Update(context.Background(), c)
+	require.ErrorIs(t, err, utils.ErrPreconditionFailed)
+
+	c.ID = 2
+
+	_, err = svc.Update(context.Background(), c)
+	require.ErrorIs(t, err, utils.ErrNotFound)
+
+	history, err = auditRepo.GetByCompanyID(context.Background(), 1, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, history, 1, "failed changes leave no audit entry")
+}
+
+func TestReplace(t *testing.T) {
+	svc, m, _ := getTestService(t)
+
+	c := companyRepo.Company{ID: 1, Name: "new", Code: "code", Version: 1}
+
+	m.On("Lock", 1).Return(c, nil)
+	m.On("Lock", 2).Return(companyRepo.Company{}, sql.ErrNoRows)
+	m.On("Replace", c).Return(companyRepo.Company{ID: 1, Name: "new", Code: "code", Version: 2}, nil).Once()
+
+	updated, err := svc.Replace(context.Background(), c)
+	require.NoError(t, err)
+	require.Equal(t, 2, updated.Version)
+
+	m.On("Replace", c).Return(companyRepo.Company{}, companyRepo.ErrVersionMismatch).Once()
+
+	_, err = svc.Replace(context.Background(), c)
+	require.ErrorIs(t, err, utils.ErrPreconditionFailed)
+
+	c.ID = 2
 
-	c := companyRepo.Company{}
+	_, err = svc.Replace(context.Background(), c)
+	require.ErrorIs(t, err, utils.ErrNotFound)
+}
+
+func TestPatch(t *testing.T) {
+	svc, m, auditRepo := getTestService(t)
+
+	current := companyRepo.Company{ID: 1, Name: "old", Code: "code", Status: "active", Version: 3}
+	patched := companyRepo.Company{ID: 1, Name: "new", Code: "code", Version: 3}
+
+	m.On("Lock", 1).Return(current, nil)
+	m.On("Lock", 2).Return(companyRepo.Company{ID: 2, Status: "deleted"}, nil)
+	m.On("Replace", patched).Return(companyRepo.Company{ID: 1, Name: "new", Code: "code", Status: "active", Version: 4}, nil).Once()
+
+	rename := func(c companyRepo.Company) (companyRepo.Company, error) {
+		return companyRepo.Company{Name: "new", Code: c.Code}, nil
+	}
 
-	m.On("Update", c).Return(nil)
+	updated, err := svc.Patch(context.Background(), 1, 3, rename)
+	require.NoError(t, err)
+	require.Equal(t, 4, updated.Version)
 
-	err := svc.Update(c)
+	history, err := auditRepo.GetByCompanyID(context.Background(), 1, 10, 0)
 	require.NoError(t, err)
+	require.Equal(t, audit.ActionUpdate, history[0].Action)
+
+	_, err = svc.Patch(context.Background(), 1, 2, rename)
+	require.ErrorIs(t, err, utils.ErrPreconditionFailed)
+
+	_, err = svc.Patch(context.Background(), 2, 0, rename)
+	require.ErrorIs(t, err, utils.ErrNotFound)
+
+	errRejected := errors.New("rejected")
+	_, err = svc.Patch(context.Background(), 1, 0, func(c companyRepo.Company) (companyRepo.Company, error) {
+		return companyRepo.Company{}, errRejected
+	})
+	require.ErrorIs(t, err, errRejected)
 }
 
 func TestDeleteByID(t *testing.T) {
-	svc, m := getTestService(t)
+	svc, m, auditRepo := getTestService(t)
 
-	m.On("DeleteByID", 1).Return(nil)
+	m.On("Lock", 1).Return(companyRepo.Company{ID: 1, Status: "active"}, nil).Once()
+	m.On("DeleteByID", 1, 0).Return(nil)
+	m.On("Lock", 1).Return(companyRepo.Company{ID: 1, Status: "deleted"}, nil).Once()
 
-	err := svc.DeleteByID(1)
+	err := svc.DeleteByID(context.Background(), 1, 0)
 	require.NoError(t, err)
 
-	m.On("DeleteByID", 2).Return(sql.ErrNoRows)
+	history, err := auditRepo.GetByCompanyID(context.Background(), 1, 10, 0)
+	require.NoError(t, err)
+	require.Equal(t, audit.ActionDelete, history[0].Action)
+	require.Contains(t, string(history[0].Before), `"status":"active"`)
+	require.Contains(t, string(history[0].After), `"status":"deleted"`)
+
+	m.On("Lock", 2).Return(companyRepo.Company{ID: 2, Status: "deleted"}, nil)
+	m.On("Lock", 3).Return(companyRepo.Company{ID: 3, Status: "active", Version: 5}, nil)
+
+	m.On("DeleteByID", 2, 0).Return(sql.ErrNoRows)
 
-	err = svc.DeleteByID(2)
+	err = svc.DeleteByID(context.Background(), 2, 0)
 	require.ErrorIs(t, err, utils.ErrNotFound)
+
+	m.On("DeleteByID", 3, 4).Return(companyRepo.ErrVersionMismatch)
+
+	err = svc.DeleteByID(context.Background(), 3, 4)
+	require.ErrorIs(t, err, utils.ErrPreconditionFailed)
 }
 
-func getTestService(t *testing.T) (company.Service, *mocker) {
+func TestRestore(t *testing.T) {
+	svc, m, _ := getTestService(t)
+
+	for id := 1; id <= 3; id++ {
+		m.On("Lock", id).Return(companyRepo.Company{ID: id, Status: "deleted"}, nil)
+	}
+	m.On("Restore", 1, 0).Return(companyRepo.Company{ID: 1, Status: "active", Version: 3}, nil)
+
+	restored, err := svc.Restore(context.Background(), 1, 0)
+	require.NoError(t, err)
+	require.Equal(t, 3, restored.Version)
+
+	m.On("Restore", 2, 0).Return(companyRepo.Company{}, sql.ErrNoRows)
+
+	_, err = svc.Restore(context.Background(), 2, 0)
+	require.ErrorIs(t, err, utils.ErrNotFound)
+
+	m.On("Restore", 3, 0).Return(companyRepo.Company{}, &pq.Error{Code: "23505", Constraint: "companies_code_key"})
+
+	_, err = svc.Restore(context.Background(), 3, 0)
+	require.EqualError(t, err, "code already exists")
+}
+
+func TestPurge(t *testing.T) {
+	svc, m, auditRepo := getTestService(t)
+
+	m.On("Purge", time.Hour, 2).Return([]companyRepo.Company{{ID: 1}, {ID: 2}}, nil).Once()
+	m.On("Purge", time.Hour, 2).Return([]companyRepo.Company{{ID: 3, Name: "gone", Status: "deleted"}}, nil).Once()
+
+	n, err := svc.Purge(context.Background(), time.Hour, 2, false)
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+
+	history, err := auditRepo.GetByCompanyID(context.Background(), 3, 10, 0)
+	require.NoError(t, err)
+	require.Equal(t, audit.ActionPurge, history[0].Action)
+	require.Zero(t, history[0].ActorID)
+	require.Contains(t, string(history[0].Before), `"name":"gone"`)
+	require.Empty(t, history[0].After)
+
+	m.On("Purge", time.Minute, 2).Return([]companyRepo.Company{{ID: 4}, {ID: 5}}, nil).Once()
+	m.On("Purge", time.Minute, 2).Return(nil, errors.New("some error")).Once()
+
+	n, err = svc.Purge(context.Background(), time.Minute, 2, false)
+	require.Error(t, err)
+	require.Equal(t, 2, n)
+
+	m.On("CountPurgeable", time.Hour).Return(7, nil).Once()
+
+	n, err = svc.Purge(context.Background(), time.Hour, 2, true)
+	require.NoError(t, err)
+	require.Equal(t, 7, n)
+
+	m.AssertExpectations(t)
+}
+
+func TestHistory(t *testing.T) {
+	svc, _, auditRepo := getTestService(t)
+
+	require.NoError(t, auditRepo.Create(context.Background(), audit.Entry{CompanyID: 1, Action: audit.ActionCreate}))
+
+	entries, err := svc.History(context.Background(), 1, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	_, err = svc.History(context.Background(), 2, 10, 0)
+	require.ErrorIs(t, err, utils.ErrNotFound)
+}
+
+func getTestService(t *testing.T) (company.Service, *mocker, audit.Repository) {
+	t.Setenv("XM_DATABASE_PASSWORD", "")
+	t.Setenv("XM_AUTH_JWT_KEY", "test-key")
+	t.Setenv("XM_LOGGING_OUTPUTS", "stderr")
+
 	var repo company.Service
 	m := &mocker{}
+	auditRepo := audit.NewMemory()
 
-	go fxtest.New(
-		fxtest.TB(t),
+	// The app is only built, not started, so no lifecycle hook such as
+	// configs.Watch outlives the test.
+	fxtest.New(
+		t,
 		fx.Options(
 			configs.Module,
 			logger.Module,
@@ -107,43 +308,74 @@ func getTestService(t *testing.T) (company.Service, *mocker) {
 				func() companyRepo.Repository {
 					return m
 				},
+				func() audit.Repository {
+					return auditRepo
+				},
+				db.NopTxManager,
 			),
 
 			services.Module,
 		),
 		fx.Populate(&repo),
-	).Run()
+	)
 
-	return repo, m
+	return repo, m, auditRepo
 }
 
 type mocker struct {
 	mock.Mock
 }
 
-func (m *mocker) Create(c companyRepo.Company) (err error) {
+func (m *mocker) Create(_ context.Context, c companyRepo.Company) (created companyRepo.Company, err error) {
 	args := m.Called(c)
-	return args.Error(0)
+	return args.Get(0).(companyRepo.Company), args.Error(1)
+}
+
+func (m *mocker) Lock(_ context.Context, id int) (c companyRepo.Company, err error) {
+	args := m.Called(id)
+	return args.Get(0).(companyRepo.Company), args.Error(1)
 }
 
-func (m *mocker) GetByID(id int) (c companyRepo.Company, err error) {
+func (m *mocker) GetByID(_ context.Context, id int) (c companyRepo.Company, err error) {
 	args := m.Called(id)
 	return args.Get(0).(companyRepo.Company), args.Error(1)
 }
 
-func (m *mocker) GetAll(f companyRepo.Filters) (companies []companyRepo.Company, err error) {
+func (m *mocker) GetAll(_ context.Context, f companyRepo.Filters) (companies []companyRepo.Company, err error) {
 	args := m.Called(f)
 	companies, _ = args.Get(0).([]companyRepo.Company)
 
 	return companies, args.Error(1)
 }
 
-func (m *mocker) Update(c companyRepo.Company) (err error) {
+func (m *mocker) Update(_ context.Context, c companyRepo.Company) (updated companyRepo.Company, err error) {
 	args := m.Called(c)
-	return args.Error(0)
+	return args.Get(0).(companyRepo.Company), args.Error(1)
 }
 
-func (m *mocker) DeleteByID(id int) (err error) {
-	args := m.Called(id)
+func (m *mocker) Replace(_ context.Context, c companyRepo.Company) (updated companyRepo.Company, err error) {
+	args := m.Called(c)
+	return args.Get(0).(companyRepo.Company), args.Error(1)
+}
+
+func (m *mocker) DeleteByID(_ context.Context, id, version int) (err error) {
+	args := m.Called(id, version)
 	return args.Error(0)
 }
+
+func (m *mocker) Restore(_ context.Context, id, version int) (restored companyRepo.Company, err error) {
+	args := m.Called(id, version)
+	return args.Get(0).(companyRepo.Company), args.Error(1)
+}
+
+func (m *mocker) Purge(_ context.Context, olderThan time.Duration, limit int) (purged []companyRepo.Company, err error) {
+	args := m.Called(olderThan, limit)
+	purged, _ = args.Get(0).([]companyRepo.Company)
+
+	return purged, args.Error(1)
+}
+
+func (m *mocker) CountPurgeable(_ context.Context, olderThan time.Duration) (n int, err error) {
+	args := m.Called(olderThan)
+	return args.Int(0), args.Error(1)
+}
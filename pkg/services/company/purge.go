@@ -0,0 +1,85 @@
+package company
+
+import (
+	"context"
+	"time"
+	"xm/configs"
+	"xm/pkg/logger"
+
+	"go.uber.org/fx"
+)
+
+type PurgeParams struct {
+	fx.In
+	Lifecycle fx.Lifecycle
+	Service   Service
+	Configs   configs.Configs
+	Logger    logger.Logger
+}
+
+// SchedulePurge runs Service.Purge every purge.interval with the
+// retention, batch size and dry-run setting current at the time.
+func SchedulePurge(p PurgeParams) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+
+	p.Lifecycle.Append(
+		fx.Hook{
+			OnStart: func(context.Context) error {
+				go func() {
+					defer close(stopped)
+					purgeEvery(ctx, p, p.Configs.Peek().Purge.Interval.Duration)
+				}()
+
+				return nil
+			},
+			OnStop: func(stopCtx context.Context) error {
+				cancel()
+
+				select {
+				case <-stopped:
+				case <-stopCtx.Done():
+				}
+
+				return nil
+			},
+		},
+	)
+}
+
+func purgeEvery(ctx context.Context, p PurgeParams, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purge(ctx, p)
+		}
+	}
+}
+
+func purge(ctx context.Context, p PurgeParams) {
+	cfg := p.Configs.Peek().Purge
+	if cfg.Retention.Duration <= 0 {
+		return
+	}
+
+	log := p.Logger.Logger()
+	start := time.Now()
+
+	n, err := p.Service.Purge(ctx, cfg.Retention.Duration, cfg.BatchSize, cfg.DryRun)
+	if err != nil {
+		log.Errorw("company purge failed", "purged", n, "error", err)
+		return
+	}
+
+	if cfg.DryRun {
+		log.Infow("company purge dry run", "wouldPurge", n, "retention", cfg.Retention.Duration)
+		return
+	}
+
+	log.Infow("company purge", "purged", n, "retention", cfg.Retention.Duration, "took", time.Since(start))
+}
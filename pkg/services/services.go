@@ -11,3 +11,8 @@ var Module = fx.Options(
 	user.Module,
 	company.Module,
 )
+
+// Jobs starts the services' background work; only serve runs it.
+var Jobs = fx.Options(
+	company.Jobs,
+)
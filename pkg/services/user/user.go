@@ -1,7 +1,9 @@
 package user
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"xm/pkg/repositories/user"
 	"xm/pkg/services/utils"
 
@@ -12,8 +14,9 @@ import (
 var Module = fx.Provide(New)
 
 type Service interface {
-	Create(u *user.User) error
-	GetByUsername(username string) (*user.User, error)
+	Create(ctx context.Context, u *user.User) error
+	GetByUsername(ctx context.Context, username string) (*user.User, error)
+	SetPassword(ctx context.Context, username, password string) error
 }
 
 type service struct {
@@ -31,33 +34,42 @@ func New(p Params) Service {
 	}
 }
 
-func (s *service) Create(u *user.User) error {
-	err := s.userRepository.Create(u)
+func (s *service) Create(ctx context.Context, u *user.User) error {
+	err := s.userRepository.Create(ctx, u)
 	if err != nil {
-		v, ok := err.(*pq.Error)
-		if !ok {
-			return err
-		}
-
-		if v.Code == "23505" {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
 			return utils.ErrAlreadyExists
 		}
 
-		return err
+		return utils.ContextError(ctx, err)
 	}
 
 	return nil
 }
 
-func (s *service) GetByUsername(username string) (*user.User, error) {
-	u, err := s.userRepository.GetByUsername(username)
+func (s *service) GetByUsername(ctx context.Context, username string) (*user.User, error) {
+	u, err := s.userRepository.GetByUsername(ctx, username)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, utils.ErrNotFound
 		}
 
-		return nil, err
+		return nil, utils.ContextError(ctx, err)
 	}
 
 	return u, nil
 }
+
+func (s *service) SetPassword(ctx context.Context, username, password string) error {
+	err := s.userRepository.UpdatePassword(ctx, username, password)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return utils.ErrNotFound
+		}
+
+		return utils.ContextError(ctx, err)
+	}
+
+	return nil
+}
@@ -1,7 +1,9 @@
 package user_test
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	"testing"
 	"xm/configs"
 	"xm/pkg/logger"
@@ -27,11 +29,15 @@ func TestCreate(t *testing.T) {
 
 	m.On("Create", &req).Return(nil).Once()
 
-	err := svc.Create(&req)
+	err := svc.Create(context.Background(), &req)
 	require.NoError(t, err)
 
-	m.On("Create", &req).Return(error(&pq.Error{Code: "23505"}))
-	err = svc.Create(&req)
+	m.On("Create", &req).Return(error(&pq.Error{Code: "23505"})).Once()
+	err = svc.Create(context.Background(), &req)
+	require.ErrorIs(t, err, utils.ErrAlreadyExists)
+
+	m.On("Create", &req).Return(fmt.Errorf("tx: %w", &pq.Error{Code: "23505"})).Once()
+	err = svc.Create(context.Background(), &req)
 	require.ErrorIs(t, err, utils.ErrAlreadyExists)
 }
 
@@ -45,7 +51,7 @@ func TestGetByUsername(t *testing.T) {
 
 	m.On("GetByUsername", req.Username).Return(&req, nil)
 
-	u, err := svc.GetByUsername(req.Username)
+	u, err := svc.GetByUsername(context.Background(), req.Username)
 	require.NoError(t, err)
 
 	require.Equal(t, u.Password, req.Password)
@@ -56,18 +62,60 @@ func TestGetByUsername(t *testing.T) {
 
 	m.On("GetByUsername", req.Username).Return(nil, sql.ErrNoRows)
 
-	u, err = svc.GetByUsername(req.Username)
+	u, err = svc.GetByUsername(context.Background(), req.Username)
 	require.Equal(t, err, utils.ErrNotFound)
 
 	require.Nil(t, u)
 }
 
+func TestSetPassword(t *testing.T) {
+	svc, m := getTestService(t)
+
+	m.On("UpdatePassword", "some", "hash").Return(nil).Once()
+
+	err := svc.SetPassword(context.Background(), "some", "hash")
+	require.NoError(t, err)
+
+	m.On("UpdatePassword", "missing", "hash").Return(sql.ErrNoRows).Once()
+
+	err = svc.SetPassword(context.Background(), "missing", "hash")
+	require.ErrorIs(t, err, utils.ErrNotFound)
+}
+
+func TestContextErrors(t *testing.T) {
+	svc, m := getTestService(t)
+
+	m.On("GetByUsername", "slow").Return(nil, error(&pq.Error{Code: "57014"}))
+
+	_, err := svc.GetByUsername(context.Background(), "slow")
+	require.ErrorIs(t, err, utils.ErrTimeout)
+
+	m.On("GetByUsername", "wrapped").Return(nil, fmt.Errorf("tx: %w", &pq.Error{Code: "57014"}))
+
+	_, err = svc.GetByUsername(context.Background(), "wrapped")
+	require.ErrorIs(t, err, utils.ErrTimeout)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	m.On("GetByUsername", "gone").Return(nil, context.Canceled)
+
+	_, err = svc.GetByUsername(ctx, "gone")
+	require.ErrorIs(t, err, utils.ErrCanceled)
+}
+
 func getTestService(t *testing.T) (user.Service, *mocker) {
+	t.Setenv("XM_DATABASE_PASSWORD", "")
+	t.Setenv("XM_AUTH_JWT_KEY", "test-key")
+	t.Setenv("XM_LOGGING_OUTPUTS", "stderr")
+
 	var repo user.Service
 	m := &mocker{}
 
-	go fxtest.New(
-		fxtest.TB(t),
+	// The app is only built, not started, so no lifecycle hook such as
+	// configs.Watch outlives the test.
+	fxtest.New(
+		t,
 		fx.Options(
 			configs.Module,
 			logger.Module,
@@ -81,7 +129,7 @@ func getTestService(t *testing.T) (user.Service, *mocker) {
 			services.Module,
 		),
 		fx.Populate(&repo),
-	).Run()
+	)
 
 	return repo, m
 }
@@ -90,12 +138,12 @@ type mocker struct {
 	mock.Mock
 }
 
-func (m *mocker) Create(u *userRepo.User) error {
+func (m *mocker) Create(_ context.Context, u *userRepo.User) error {
 	args := m.Called(u)
 	return args.Error(0)
 }
 
-func (m *mocker) GetByUsername(username string) (*userRepo.User, error) {
+func (m *mocker) GetByUsername(_ context.Context, username string) (*userRepo.User, error) {
 	var u *userRepo.User
 
 	args := m.Called(username)
@@ -103,3 +151,8 @@ func (m *mocker) GetByUsername(username string) (*userRepo.User, error) {
 
 	return u, args.Error(1)
 }
+
+func (m *mocker) UpdatePassword(_ context.Context, username, password string) error {
+	args := m.Called(username, password)
+	return args.Error(0)
+}
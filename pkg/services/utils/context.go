@@ -0,0 +1,30 @@
+package utils
+
+import "context"
+
+type actorKey struct{}
+
+type requestIDKey struct{}
+
+// WithActor records on ctx the id of the user the request acts for.
+func WithActor(ctx context.Context, userID int) context.Context {
+	return context.WithValue(ctx, actorKey{}, userID)
+}
+
+// Actor returns the user id set by WithActor, or zero for work that is
+// not done on behalf of a user, such as scheduled jobs.
+func Actor(ctx context.Context) int {
+	id, _ := ctx.Value(actorKey{}).(int)
+	return id
+}
+
+// WithRequestID records on ctx the id of the request being served.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the id set by WithRequestID, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
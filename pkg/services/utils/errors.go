@@ -1,8 +1,53 @@
 package utils
 
-import "errors"
+import (
+	"context"
+	"errors"
+
+	"github.com/lib/pq"
+)
 
 var (
-	ErrAlreadyExists = errors.New("already exists")
-	ErrNotFound      = errors.New("not found")
+	ErrAlreadyExists      = errors.New("already exists")
+	ErrNotFound           = errors.New("not found")
+	ErrPreconditionFailed = errors.New("precondition failed")
+	ErrCanceled           = errors.New("canceled")
+	ErrTimeout            = errors.New("timed out")
 )
+
+// ConflictError is an ErrAlreadyExists that names the field whose value
+// is already taken.
+type ConflictError struct {
+	Field string
+}
+
+func (e *ConflictError) Error() string {
+	return e.Field + " already exists"
+}
+
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrAlreadyExists
+}
+
+// ContextError maps err to ErrCanceled or ErrTimeout when it was caused by
+// ctx ending or by the query running past its timeout, and returns it
+// unchanged otherwise. Postgres reports a canceled statement as
+// query_canceled rather than the context's error, so both are checked.
+func ContextError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var pqErr *pq.Error
+	canceled := errors.As(err, &pqErr) && pqErr.Code == "57014"
+
+	if !canceled && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return ErrCanceled
+	}
+
+	return ErrTimeout
+}
@@ -0,0 +1,13 @@
+#!/bin/sh
+# Creates the secrets docker-compose and configs/configs.json refer to,
+# with random values, leaving any that already exist alone.
+set -e
+
+cd "$(dirname "$0")"
+
+for name in db_password jwt_key; do
+	if [ ! -s "$name" ]; then
+		head -c 32 /dev/urandom | od -An -tx1 | tr -d ' \n' > "$name"
+		echo "created secrets/$name"
+	fi
+done